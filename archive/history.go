@@ -0,0 +1,120 @@
+package archive
+
+import (
+	"sort"
+
+	"github.com/tsudoko/pullcord/logentry"
+	"github.com/tsudoko/pullcord/logfile"
+)
+
+// historyTypes are the record types whose IDs identify a single object.
+var historyTypes = map[string]bool{
+	"message":       true,
+	"attachment":    true,
+	"snapshot":      true,
+	"poll":          true,
+	"guild":         true,
+	"member":        true,
+	"ban":           true,
+	"role":          true,
+	"channel":       true,
+	"permoverwrite": true,
+	"emoji":         true,
+}
+
+// FieldChange is a field which differs from the previous record of an
+// object.
+type FieldChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// Change is a single record of an object, with the fields which changed
+// since its previous record. The first record of an object lists all its
+// non-empty fields.
+type Change struct {
+	logentry.Record
+	ID     string
+	Log    string // name of the log the record is in
+	Fields []FieldChange
+}
+
+// diff compares the type-specific fields of two records, except for the ID.
+// Missing fields are empty.
+func diff(s *logentry.Schema, old, new []string) []FieldChange {
+	field := func(e []string, i int) string {
+		if i += logentry.HID; i < len(e) {
+			return e[i]
+		}
+		return ""
+	}
+
+	var changes []FieldChange
+	for i := 1; i < len(s.Fields); i++ {
+		f := s.Fields[i]
+		if o, n := field(old, i), field(new, i); o != n {
+			changes = append(changes, FieldChange{f.Name, o, n})
+		}
+	}
+	return changes
+}
+
+// History returns all records of objects with the given ID in the logs of a
+// guild, or of all guilds if gid is empty, ordered by time. If typ isn't
+// empty, only objects of that type are included.
+func (a *Archive) History(gid, id, typ string) ([]*Change, error) {
+	logs, err := a.logs()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for g, cids := range logs {
+		if gid != "" && g != gid {
+			continue
+		}
+		for _, cid := range cids {
+			if cid == "" {
+				names = append(names, GuildLog(g))
+			} else {
+				names = append(names, ChannelLog(g, cid))
+			}
+		}
+	}
+	sort.Strings(names)
+
+	var changes []*Change
+	for _, name := range names {
+		prev := make(map[string][]string)
+		err := logfile.Decode(a.st, name, func(e []string) error {
+			if len(e) <= logentry.HID || e[logentry.HID] != id {
+				return nil
+			}
+			t := e[logentry.HType]
+			if !historyTypes[t] || typ != "" && t != typ {
+				return nil
+			}
+
+			rec, err := logentry.Parse(e)
+			if err != nil {
+				return err
+			}
+
+			changes = append(changes, &Change{
+				Record: *logentry.Header(rec),
+				ID:     id,
+				Log:    name,
+				Fields: diff(logentry.Lookup(t), prev[t], e),
+			})
+			prev[t] = e
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Time.Before(changes[j].Time) })
+	return changes, nil
+}
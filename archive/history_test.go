@@ -0,0 +1,116 @@
+package archive
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/tsudoko/pullcord/logentry"
+	"github.com/tsudoko/pullcord/storage"
+)
+
+func TestHistory(t *testing.T) {
+	st := storage.Dir(t.TempDir())
+	guildFixture(t, st)
+	edited := record(6, "", "", "")[0]
+	writeLog(t, st, ChannelLog("1", "2"),
+		record(3, "add", "message", "8", "7", "", "", "hi"),
+		// not an object of its own
+		record(3, "add", "reaction", "8", "8", "👍", "1"),
+		record(6, "add", "message", "8", "7", edited, "", "hi!"),
+		record(7, "del", "message", "8", "7", edited, "", "hi!"),
+	)
+	writeLog(t, st, ChannelLog("2", "3"),
+		record(4, "add", "message", "8", "9", "", "", "elsewhere"),
+	)
+	a := New(st)
+
+	type change struct {
+		day    int
+		op     string
+		typ    string
+		log    string
+		fields []FieldChange
+	}
+	guildLog, channelLog := GuildLog("1"), ChannelLog("1", "2")
+	all := []change{
+		{2, "add", "member", guildLog, []FieldChange{{"username", "", "bob"}, {"discriminator", "", "0002"}}},
+		{3, "add", "message", channelLog, []FieldChange{{"authorid", "", "7"}, {"content", "", "hi"}}},
+		{5, "add", "permoverwrite", guildLog, []FieldChange{{"overwritetype", "", "member"}, {"allow", "", "1024"}, {"deny", "", "0"}}},
+		{5, "add", "member", guildLog, []FieldChange{{"nick", "", "bobby"}}},
+		{6, "add", "message", channelLog, []FieldChange{{"editedtime", "", edited}, {"content", "hi", "hi!"}}},
+		{7, "del", "message", channelLog, nil},
+	}
+
+	tests := []struct {
+		gid, typ string
+		want     []change
+	}{
+		{"1", "", all},
+		{"1", "member", []change{all[0], all[3]}},
+		{"1", "message", []change{all[1], all[4], all[5]}},
+		{"1", "reaction", nil},
+		{"2", "", []change{{4, "add", "message", ChannelLog("2", "3"), []FieldChange{{"authorid", "", "9"}, {"content", "", "elsewhere"}}}}},
+		{"", "message", []change{
+			all[1],
+			{4, "add", "message", ChannelLog("2", "3"), []FieldChange{{"authorid", "", "9"}, {"content", "", "elsewhere"}}},
+			all[4],
+			all[5],
+		}},
+	}
+	for _, tt := range tests {
+		changes, err := a.History(tt.gid, "8", tt.typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []change
+		for _, c := range changes {
+			if c.ID != "8" {
+				t.Errorf("got a change of %s", c.ID)
+			}
+			got = append(got, change{c.Time.Day(), c.Op, c.Type, c.Log, c.Fields})
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("guild %q, type %q:\ngot  %s\nwant %s", tt.gid, tt.typ, fmt.Sprint(got), fmt.Sprint(tt.want))
+		}
+	}
+
+	// a role which changed and was deleted, in a rotated log
+	changes, err := a.History("1", "5", "role")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got [][]FieldChange
+	for _, c := range changes {
+		got = append(got, c.Fields)
+	}
+	want := [][]FieldChange{
+		{{"name", "", "role"}, {"color", "", "0"}, {"pos", "", "1"}, {"perms", "", "0"}},
+		{{"name", "role", "mods"}, {"perms", "0", "8"}},
+		nil,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("role:\ngot  %v\nwant %v", got, want)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	s := logentry.Lookup("emoji")
+	tests := []struct {
+		old, new []string
+		want     []FieldChange
+	}{
+		{nil, record(1, "add", "emoji", "30", "x"), []FieldChange{{"name", "", "x"}}},
+		{record(1, "add", "emoji", "30", "x"), record(2, "add", "emoji", "30", "x"), nil},
+		{record(1, "add", "emoji", "30", "x"), record(2, "add", "emoji", "30", "y", "nocolons"), []FieldChange{{"name", "x", "y"}, {"nocolons", "", "nocolons"}}},
+		// missing trailing fields are empty
+		{record(1, "add", "emoji", "30", "x", ""), record(2, "add", "emoji", "30", "x"), nil},
+		{record(1, "add", "emoji", "30", "x", "nocolons"), record(2, "add", "emoji", "30", "x"), []FieldChange{{"nocolons", "nocolons", ""}}},
+	}
+	for _, tt := range tests {
+		if got := diff(s, tt.old, tt.new); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("diff(%q, %q) = %v, want %v", tt.old, tt.new, got, tt.want)
+		}
+	}
+}
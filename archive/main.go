@@ -0,0 +1,220 @@
+// Package archive reads archives written by pullcord.
+package archive
+
+import (
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/tsudoko/pullcord/logentry"
+	"github.com/tsudoko/pullcord/logfile"
+	"github.com/tsudoko/pullcord/storage"
+)
+
+// DMGuildID is the guild ID DM channels are stored under.
+const DMGuildID = "@me"
+
+type Archive struct {
+	st storage.Storage
+}
+
+// Open opens the archive described by spec, see storage.Open.
+func Open(spec string) (*Archive, error) {
+	st, err := storage.Open(spec)
+	if err != nil {
+		return nil, err
+	}
+	return New(st), nil
+}
+
+func New(st storage.Storage) *Archive {
+	return &Archive{st}
+}
+
+// Storage returns the storage the archive is read from, e.g. for opening
+// downloaded files.
+func (a *Archive) Storage() storage.Storage {
+	return a.st
+}
+
+func (a *Archive) Close() error {
+	return a.st.Close()
+}
+
+type Guild struct {
+	ID   string
+	Name string // empty for DMs
+
+	Record  *logentry.GuildRecord // the last guild record, nil if there's none
+	Deleted bool                  // if the guild wasn't present during the last pull
+}
+
+type Channel struct {
+	ID      string
+	GuildID string
+	Name    string // empty for DMs and channels without records
+	Log     string // name of the channel log
+
+	Record  *logentry.ChannelRecord // the last channel record, nil if there's none
+	Deleted bool                    // if the channel wasn't present during the last pull
+}
+
+// LessID orders snowflake IDs by time.
+func LessID(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// parse decodes a record, records of types added by newer versions are
+// skipped and returned as nil without an error.
+func parse(e []string) (interface{}, error) {
+	rec, err := logentry.Parse(e)
+	if _, ok := err.(logentry.UnknownTypeError); ok {
+		return nil, nil
+	}
+	return rec, err
+}
+
+// GuildLog returns the name of the log of a guild.
+func GuildLog(gid string) string {
+	return path.Join("channels", gid, "guild.tsv")
+}
+
+// ChannelLog returns the name of the log of a channel.
+func ChannelLog(gid, cid string) string {
+	return path.Join("channels", gid, cid+".tsv")
+}
+
+// logs returns the guild and channel IDs of all logs in the archive, as
+// guild ID -> channel IDs. Guild logs are listed with an empty channel ID.
+func (a *Archive) logs() (map[string][]string, error) {
+	names, err := a.st.List("channels")
+	if err != nil {
+		return nil, err
+	}
+
+	logs := make(map[string][]string)
+	for _, name := range names {
+		if ok, _ := path.Match("channels/*/*.tsv", name); !ok {
+			continue
+		}
+
+		gid := path.Base(path.Dir(name))
+		cid := strings.TrimSuffix(path.Base(name), ".tsv")
+		if name == GuildLog(gid) {
+			cid = ""
+		}
+		logs[gid] = append(logs[gid], cid)
+	}
+	return logs, nil
+}
+
+// last keeps the last record of every object of a log, deleted or not.
+type last struct {
+	records map[string]map[string]interface{}
+	deleted map[string]map[string]bool
+}
+
+func (a *Archive) readLast(name string) (*last, error) {
+	l := &last{make(map[string]map[string]interface{}), make(map[string]map[string]bool)}
+	err := logfile.Decode(a.st, name, func(e []string) error {
+		rec, err := parse(e)
+		if rec == nil {
+			return err
+		}
+
+		typ, id := e[logentry.HType], e[logentry.HID]
+		if l.records[typ] == nil {
+			l.records[typ] = make(map[string]interface{})
+			l.deleted[typ] = make(map[string]bool)
+		}
+		l.records[typ][id] = rec
+		l.deleted[typ][id] = e[logentry.HOp] == "del"
+		return nil
+	})
+	return l, err
+}
+
+// Guilds returns all guilds with logs in the archive, ordered by ID. DM
+// channels are listed under a guild with the ID DMGuildID.
+func (a *Archive) Guilds() ([]*Guild, error) {
+	logs, err := a.logs()
+	if err != nil {
+		return nil, err
+	}
+
+	var guilds []*Guild
+	for gid := range logs {
+		g, err := a.Guild(gid)
+		if err != nil {
+			return nil, err
+		}
+		guilds = append(guilds, g)
+	}
+
+	sort.Slice(guilds, func(i, j int) bool { return LessID(guilds[i].ID, guilds[j].ID) })
+	return guilds, nil
+}
+
+// Guild returns a guild with its name resolved from its log.
+func (a *Archive) Guild(gid string) (*Guild, error) {
+	g := &Guild{ID: gid}
+	if gid == DMGuildID {
+		return g, nil
+	}
+
+	l, err := a.readLastOptional(GuildLog(gid))
+	if err != nil {
+		return nil, err
+	}
+	if r, ok := l.records["guild"][gid].(*logentry.GuildRecord); ok {
+		g.Name = r.Name
+		g.Record = r
+		g.Deleted = l.deleted["guild"][gid]
+	}
+	return g, nil
+}
+
+// readLastOptional is readLast for logs which might not exist.
+func (a *Archive) readLastOptional(name string) (*last, error) {
+	l, err := a.readLast(name)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Channels returns the channels of a guild which have logs, ordered by ID,
+// with their names resolved from the guild log.
+func (a *Archive) Channels(gid string) ([]*Channel, error) {
+	logs, err := a.logs()
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := a.readLastOptional(GuildLog(gid))
+	if err != nil {
+		return nil, err
+	}
+
+	var chans []*Channel
+	for _, cid := range logs[gid] {
+		if cid == "" {
+			continue
+		}
+
+		c := &Channel{ID: cid, GuildID: gid, Log: ChannelLog(gid, cid)}
+		if r, ok := l.records["channel"][cid].(*logentry.ChannelRecord); ok {
+			c.Name = r.Name
+			c.Record = r
+			c.Deleted = l.deleted["channel"][cid]
+		}
+		chans = append(chans, c)
+	}
+
+	sort.Slice(chans, func(i, j int) bool { return LessID(chans[i].ID, chans[j].ID) })
+	return chans, nil
+}
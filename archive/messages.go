@@ -0,0 +1,247 @@
+package archive
+
+import (
+	"sort"
+
+	"github.com/tsudoko/pullcord/logentry"
+	"github.com/tsudoko/pullcord/logfile"
+)
+
+// Message is the last state of a message with the records related to it.
+// Attachments and embeds of a forwarded message have Snapshot set.
+type Message struct {
+	*logentry.MessageRecord
+	Deleted bool
+	Pinned  bool
+
+	Attachments []*logentry.AttachmentRecord
+	Embeds      []*logentry.EmbedRecord
+	EmbedMedia  []*logentry.EmbedMediaRecord
+	Reactions   []*logentry.ReactionRecord
+	Snapshot    *logentry.SnapshotRecord // nil unless the message is forwarded
+
+	Poll        *logentry.PollRecord // nil if there's no poll
+	PollAnswers []*logentry.PollAnswerRecord
+	PollResults []*logentry.PollResultRecord
+	PollVotes   []*logentry.PollVoteRecord
+}
+
+// group collects the records related to a message, keyed by their IDs, so
+// that later records replace earlier ones.
+type group struct {
+	msg         *Message
+	attachments map[string]*logentry.AttachmentRecord
+	reactions   map[[2]string]*logentry.ReactionRecord
+	answers     map[int]*logentry.PollAnswerRecord
+	results     map[int]*logentry.PollResultRecord
+	votes       map[[2]interface{}]*logentry.PollVoteRecord
+	embeds      map[string]bool
+}
+
+func newGroup() *group {
+	return &group{
+		msg:         new(Message),
+		attachments: make(map[string]*logentry.AttachmentRecord),
+		reactions:   make(map[[2]string]*logentry.ReactionRecord),
+		answers:     make(map[int]*logentry.PollAnswerRecord),
+		results:     make(map[int]*logentry.PollResultRecord),
+		votes:       make(map[[2]interface{}]*logentry.PollVoteRecord),
+		embeds:      make(map[string]bool),
+	}
+}
+
+func (g *group) add(rec interface{}, del bool) {
+	m := g.msg
+	switch r := rec.(type) {
+	case *logentry.MessageRecord:
+		m.MessageRecord = r
+		m.Deleted = del
+	case *logentry.AttachmentRecord:
+		g.attachments[r.ID] = r
+		if del {
+			delete(g.attachments, r.ID)
+		}
+	case *logentry.ReactionRecord:
+		key := [2]string{r.UserID, r.Emoji}
+		g.reactions[key] = r
+		if del {
+			delete(g.reactions, key)
+		}
+	case *logentry.EmbedRecord:
+		// embeds don't have IDs, the same one is written again on every pull
+		key := logentry.Format(r)[logentry.HID+1]
+		if r.Snapshot {
+			key += "\tsnapshot"
+		}
+		if !g.embeds[key] && !del {
+			m.Embeds = append(m.Embeds, r)
+		}
+		g.embeds[key] = true
+	case *logentry.EmbedMediaRecord:
+		key := r.Kind + "\t" + r.URL + "\t" + r.Path
+		if !g.embeds[key] && !del {
+			m.EmbedMedia = append(m.EmbedMedia, r)
+		}
+		g.embeds[key] = true
+	case *logentry.SnapshotRecord:
+		m.Snapshot = r
+		if del {
+			m.Snapshot = nil
+		}
+	case *logentry.PinRecord:
+		m.Pinned = !del
+	case *logentry.PollRecord:
+		m.Poll = r
+		if del {
+			m.Poll = nil
+		}
+	case *logentry.PollAnswerRecord:
+		g.answers[r.ID] = r
+		if del {
+			delete(g.answers, r.ID)
+		}
+	case *logentry.PollResultRecord:
+		g.results[r.ID] = r
+		if del {
+			delete(g.results, r.ID)
+		}
+	case *logentry.PollVoteRecord:
+		key := [2]interface{}{r.UserID, r.AnswerID}
+		g.votes[key] = r
+		if del {
+			delete(g.votes, key)
+		}
+	}
+}
+
+// finish moves the records collected by ID into the message, in the order
+// of their IDs.
+func (g *group) finish() *Message {
+	m := g.msg
+	for _, r := range g.attachments {
+		m.Attachments = append(m.Attachments, r)
+	}
+	sort.Slice(m.Attachments, func(i, j int) bool { return LessID(m.Attachments[i].ID, m.Attachments[j].ID) })
+
+	for _, r := range g.reactions {
+		m.Reactions = append(m.Reactions, r)
+	}
+	sort.Slice(m.Reactions, func(i, j int) bool {
+		a, b := m.Reactions[i], m.Reactions[j]
+		if a.Emoji != b.Emoji {
+			return a.Emoji < b.Emoji
+		}
+		return LessID(a.UserID, b.UserID)
+	})
+
+	for _, r := range g.answers {
+		m.PollAnswers = append(m.PollAnswers, r)
+	}
+	sort.Slice(m.PollAnswers, func(i, j int) bool { return m.PollAnswers[i].ID < m.PollAnswers[j].ID })
+
+	for _, r := range g.results {
+		m.PollResults = append(m.PollResults, r)
+	}
+	sort.Slice(m.PollResults, func(i, j int) bool { return m.PollResults[i].ID < m.PollResults[j].ID })
+
+	for _, r := range g.votes {
+		m.PollVotes = append(m.PollVotes, r)
+	}
+	sort.Slice(m.PollVotes, func(i, j int) bool {
+		a, b := m.PollVotes[i], m.PollVotes[j]
+		if a.AnswerID != b.AnswerID {
+			return a.AnswerID < b.AnswerID
+		}
+		return LessID(a.UserID, b.UserID)
+	})
+
+	return m
+}
+
+// messageID returns the ID of the message a record belongs to.
+func messageID(rec interface{}) string {
+	switch r := rec.(type) {
+	case *logentry.MessageRecord:
+		return r.ID
+	case *logentry.AttachmentRecord:
+		return r.MessageID
+	case *logentry.ReactionRecord:
+		return r.MessageID
+	case *logentry.EmbedRecord:
+		return r.MessageID
+	case *logentry.EmbedMediaRecord:
+		return r.MessageID
+	case *logentry.SnapshotRecord:
+		return r.ID
+	case *logentry.PinRecord:
+		return r.MessageID
+	case *logentry.PollRecord:
+		return r.ID
+	case *logentry.PollAnswerRecord:
+		return r.MessageID
+	case *logentry.PollResultRecord:
+		return r.MessageID
+	case *logentry.PollVoteRecord:
+		return r.MessageID
+	}
+	return ""
+}
+
+// Messages calls f for every message of a channel with the records related
+// to it. The log is read twice: first to find the last record of every
+// message, then to collect the records, and f is called as soon as the last
+// one is read, so only messages with records still to come are kept in
+// memory. Messages are passed in the order of their last records, which
+// isn't the order they were sent in if they were changed later, see LessID.
+// Records of messages which weren't logged themselves are skipped.
+func (a *Archive) Messages(gid, cid string, f func(m *Message) error) error {
+	name := ChannelLog(gid, cid)
+
+	n := 0
+	last := make(map[string]int)
+	err := logfile.Decode(a.st, name, func(e []string) error {
+		rec, err := parse(e)
+		if rec == nil {
+			return err
+		}
+		n++
+		if id := messageID(rec); id != "" {
+			last[id] = n
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	n = 0
+	groups := make(map[string]*group)
+	return logfile.Decode(a.st, name, func(e []string) error {
+		rec, err := parse(e)
+		if rec == nil {
+			return err
+		}
+		n++
+
+		id := messageID(rec)
+		if id == "" {
+			return nil
+		}
+		g := groups[id]
+		if g == nil {
+			g = newGroup()
+			groups[id] = g
+		}
+		g.add(rec, e[logentry.HOp] == "del")
+
+		if last[id] != n {
+			return nil
+		}
+		delete(groups, id)
+		delete(last, id)
+		if g.msg.MessageRecord == nil {
+			return nil
+		}
+		return f(g.finish())
+	})
+}
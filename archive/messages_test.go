@@ -0,0 +1,62 @@
+package archive
+
+import (
+	"testing"
+
+	"github.com/tsudoko/pullcord/storage"
+	"github.com/tsudoko/pullcord/tsv"
+)
+
+func TestMessages(t *testing.T) {
+	st := storage.Dir(t.TempDir())
+	w, err := st.Append(ChannelLog("1", "2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const ts = "2020-01-01T00:00:00.000000+00:00"
+	for _, e := range [][]string{
+		{ts, "history", "add", "attachment", "30", "20", "a.png"},
+		{ts, "history", "add", "message", "20", "5", "", "", "second"},
+		{ts, "history", "add", "message", "10", "5", "", "", "first"},
+		{ts, "history", "add", "reaction", "5", "20", "👍", "1"},
+		// written by a newer version
+		{ts, "history", "add", "sticker", "40", "20"},
+		// the message wasn't logged
+		{ts, "history", "add", "reaction", "5", "15", "👍", "1"},
+		{ts, "realtime", "del", "message", "10", "5", "", "", "first"},
+	} {
+		tsv.Write(w, e)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var msgs []*Message
+	err = New(st).Messages("1", "2", func(m *Message) error {
+		msgs = append(msgs, m)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(msgs) != 2 {
+		t.Fatalf("got %d messages, want 2", len(msgs))
+	}
+
+	// messages are passed once their last record is read
+	m := msgs[0]
+	if m.ID != "20" || m.Content != "second" || m.Deleted {
+		t.Errorf("first message: got %+v", m.MessageRecord)
+	}
+	if len(m.Attachments) != 1 || m.Attachments[0].Filename != "a.png" {
+		t.Errorf("attachments: got %v", m.Attachments)
+	}
+	if len(m.Reactions) != 1 || m.Reactions[0].Emoji != "👍" {
+		t.Errorf("reactions: got %v", m.Reactions)
+	}
+
+	if m := msgs[1]; m.ID != "10" || !m.Deleted {
+		t.Errorf("second message: got %+v, deleted %v", m.MessageRecord, m.Deleted)
+	}
+}
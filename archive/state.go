@@ -0,0 +1,101 @@
+package archive
+
+import (
+	"time"
+
+	"github.com/tsudoko/pullcord/logentry"
+	"github.com/tsudoko/pullcord/logfile"
+)
+
+// State is the state of a guild at some point in time. Objects are keyed by
+// their IDs, ones which didn't exist at the time are left out.
+type State struct {
+	Guild          *logentry.GuildRecord // nil if the guild wasn't logged yet
+	Channels       map[string]*logentry.ChannelRecord
+	Roles          map[string]*logentry.RoleRecord
+	Members        map[string]*logentry.MemberRecord
+	Bans           map[string]*logentry.BanRecord
+	Emoji          map[string]*logentry.EmojiRecord
+	PermOverwrites map[string]*logentry.PermOverwriteRecord
+
+	// Users holds the last known record of every user, including ones who
+	// weren't members at the time, e.g. for resolving mentions.
+	Users map[string]*logentry.MemberRecord
+}
+
+func newState() *State {
+	return &State{
+		Channels:       make(map[string]*logentry.ChannelRecord),
+		Roles:          make(map[string]*logentry.RoleRecord),
+		Members:        make(map[string]*logentry.MemberRecord),
+		Bans:           make(map[string]*logentry.BanRecord),
+		Emoji:          make(map[string]*logentry.EmojiRecord),
+		PermOverwrites: make(map[string]*logentry.PermOverwriteRecord),
+		Users:          make(map[string]*logentry.MemberRecord),
+	}
+}
+
+func (s *State) apply(rec interface{}, del bool) {
+	switch r := rec.(type) {
+	case *logentry.GuildRecord:
+		s.Guild = r
+		if del {
+			s.Guild = nil
+		}
+	case *logentry.ChannelRecord:
+		s.Channels[r.ID] = r
+		if del {
+			delete(s.Channels, r.ID)
+		}
+	case *logentry.RoleRecord:
+		s.Roles[r.ID] = r
+		if del {
+			delete(s.Roles, r.ID)
+		}
+	case *logentry.MemberRecord:
+		s.Users[r.UserID] = r
+		s.Members[r.UserID] = r
+		if del {
+			delete(s.Members, r.UserID)
+		}
+	case *logentry.BanRecord:
+		s.Bans[r.UserID] = r
+		if del {
+			delete(s.Bans, r.UserID)
+		}
+	case *logentry.EmojiRecord:
+		s.Emoji[r.ID] = r
+		if del {
+			delete(s.Emoji, r.ID)
+		}
+	case *logentry.PermOverwriteRecord:
+		s.PermOverwrites[r.ID] = r
+		if del {
+			delete(s.PermOverwrites, r.ID)
+		}
+	}
+}
+
+// GuildState replays the log of a guild up to and including at. A zero at
+// replays the whole log.
+func (a *Archive) GuildState(gid string, at time.Time) (*State, error) {
+	s := newState()
+	err := logfile.Decode(a.st, GuildLog(gid), func(e []string) error {
+		rec, err := parse(e)
+		if rec == nil {
+			return err
+		}
+
+		// the clock could have gone back between pulls, so keep going
+		if !at.IsZero() && logentry.Header(rec).Time.After(at) {
+			return nil
+		}
+
+		s.apply(rec, e[logentry.HOp] == "del")
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
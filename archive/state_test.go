@@ -0,0 +1,152 @@
+package archive
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tsudoko/pullcord/logfile"
+	"github.com/tsudoko/pullcord/storage"
+	"github.com/tsudoko/pullcord/tsv"
+)
+
+func at(day int) time.Time {
+	return time.Date(2020, 1, day, 0, 0, 0, 0, time.UTC)
+}
+
+// record returns a record of the given type written on a day of January
+// 2020.
+func record(day int, op, typ string, fields ...string) []string {
+	return append([]string{at(day).Format("2006-01-02T15:04:05.000000-07:00"), "history", op, typ}, fields...)
+}
+
+func writeLog(t *testing.T, st storage.Storage, name string, records ...[]string) {
+	t.Helper()
+	w, err := st.Append(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range records {
+		tsv.Write(w, e)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// guildFixture is the log of a guild which changed over a few days.
+func guildFixture(t *testing.T, st storage.Storage) {
+	t.Helper()
+	writeLog(t, st, GuildLog("1"),
+		record(2, "add", "guild", "1", "guild", "", "", "7"),
+		record(2, "add", "role", "5", "role", "0", "1", "0"),
+		record(2, "add", "member", "7", "alice", "0001", "", "", "5"),
+		record(2, "add", "member", "8", "bob", "0002"),
+		record(2, "add", "channel", "2", "text", "0", "general"),
+
+		record(3, "add", "role", "5", "mods", "0", "1", "8"),
+		record(3, "add", "emoji", "30", "x"),
+		// written by a newer version
+		record(3, "add", "sticker", "40", "s"),
+	)
+	// older segments are read too
+	if err := logfile.Rotate(st, GuildLog("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	writeLog(t, st, GuildLog("1"),
+		record(4, "del", "member", "7", "alice", "0001", "", "", "5"),
+		record(4, "add", "ban", "7", "spam"),
+		record(4, "del", "role", "5", "mods", "0", "1", "8"),
+
+		record(5, "add", "channel", "2", "text", "0", "chat"),
+		record(5, "add", "permoverwrite", "8", "member", "1024", "0"),
+		record(5, "add", "member", "8", "bob", "0002", "", "bobby"),
+		// the clock went back between pulls
+		record(1, "add", "emoji", "31", "y"),
+	)
+}
+
+func keys[V any](m map[string]V) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+func TestGuildState(t *testing.T) {
+	st := storage.Dir(t.TempDir())
+	guildFixture(t, st)
+	a := New(st)
+
+	tests := []struct {
+		at                    time.Time
+		guild                 bool
+		channel, role, nick   string
+		members, bans, emoji  string
+		users, permOverwrites string
+	}{
+		{at(1), false, "", "", "", "", "", "31", "", ""},
+		{at(2), true, "general", "role", "", "7,8", "", "31", "7,8", ""},
+		{at(3), true, "general", "mods", "", "7,8", "", "30,31", "7,8", ""},
+		{at(4).Add(time.Hour), true, "general", "", "", "8", "7", "30,31", "7,8", ""},
+		{at(5), true, "chat", "", "bobby", "8", "7", "30,31", "7,8", "8"},
+		{time.Time{}, true, "chat", "", "bobby", "8", "7", "30,31", "7,8", "8"},
+	}
+	for _, tt := range tests {
+		s, err := a.GuildState("1", tt.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		when := tt.at.Format("Jan 2 15:04")
+		if (s.Guild != nil) != tt.guild || (s.Guild != nil && s.Guild.Name != "guild") {
+			t.Errorf("%s: guild %+v", when, s.Guild)
+		}
+
+		var channel string
+		if c := s.Channels["2"]; c != nil {
+			channel = c.Name
+		}
+		var role string
+		if r := s.Roles["5"]; r != nil {
+			role = r.Name
+		}
+		var nick string
+		if m := s.Members["8"]; m != nil {
+			nick = m.Nick
+		}
+		if channel != tt.channel || role != tt.role || nick != tt.nick {
+			t.Errorf("%s: channel %q, role %q, nick %q, want %q, %q, %q", when, channel, role, nick, tt.channel, tt.role, tt.nick)
+		}
+
+		got := []string{
+			strings.Join(keys(s.Members), ","),
+			strings.Join(keys(s.Bans), ","),
+			strings.Join(keys(s.Emoji), ","),
+			strings.Join(keys(s.Users), ","),
+			strings.Join(keys(s.PermOverwrites), ","),
+		}
+		want := []string{tt.members, tt.bans, tt.emoji, tt.users, tt.permOverwrites}
+		for i, name := range []string{"members", "bans", "emoji", "users", "permission overwrites"} {
+			if got[i] != want[i] {
+				t.Errorf("%s: %s %q, want %q", when, name, got[i], want[i])
+			}
+		}
+	}
+
+	// users who left are still known
+	s, err := a.GuildState("1", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := s.Users["7"]; u == nil || u.Username != "alice" || len(u.Roles) != 1 || u.Roles[0] != "5" {
+		t.Errorf("user who left: got %+v", u)
+	}
+
+	if _, err := a.GuildState("9", time.Time{}); err == nil {
+		t.Error("missing guild: got no error")
+	}
+}
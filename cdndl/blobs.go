@@ -0,0 +1,181 @@
+package cdndl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+
+	"github.com/tsudoko/pullcord/storage"
+)
+
+// Files downloaded with Dedup enabled are stored once per content in BlobDir,
+// named after their SHA-256 sum. The URL path layout is kept by hardlinking
+// blobs to their original paths if the storage supports it; mappings are also
+// recorded in IndexPath, in the "path,sha256" format.
+const (
+	BlobDir   = "blobs"
+	IndexPath = "blobs/index.tsv"
+)
+
+func blobPath(sum string) string {
+	return path.Join(BlobDir, sum[:2], sum)
+}
+
+func (m *Manager) exists(fPath string) bool {
+	if _, err := m.st.Stat(fPath); err == nil {
+		return true
+	}
+
+	if !m.Dedup {
+		return false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.loadIndex(); err != nil {
+		log.Printf("warning: reading the blob index failed: %v", err)
+		return false
+	}
+
+	sum, ok := m.index[fPath]
+	if !ok {
+		return false
+	}
+
+	_, err := m.st.Stat(blobPath(sum))
+	return err == nil
+}
+
+// loadIndex reads the blob index, m.mu must be held.
+func (m *Manager) loadIndex() error {
+	if m.index != nil {
+		return nil
+	}
+
+	index, err := ReadIndex(m.st)
+	if err != nil {
+		return err
+	}
+
+	m.index = index
+	return nil
+}
+
+// ReadIndex returns the mapping of URL paths to blob sums.
+func ReadIndex(st storage.Storage) (map[string]string, error) {
+	index := make(map[string]string)
+	err := readRecords(st, IndexPath, func(e []string) error {
+		if len(e) >= 2 {
+			index[e[0]] = e[1]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// store moves a complete download into the blob store and links it back.
+func (m *Manager) store(fPath, sum string) error {
+	blob := blobPath(sum)
+
+	if _, err := m.st.Stat(blob); err == nil {
+		removePartial(m.workPath(fPath))
+	} else if err := m.commit(fPath, blob); err != nil {
+		return err
+	}
+
+	if l, ok := m.st.(storage.Linker); ok {
+		if err := l.Link(blob, fPath); err != nil {
+			log.Printf("warning: %s: linking to %s failed, only recording in the index: %v", fPath, blob, err)
+		}
+	}
+
+	m.mu.Lock()
+	err := m.loadIndex()
+	if err == nil {
+		m.index[fPath] = sum
+	}
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return m.appendRecord(IndexPath, []string{fPath, sum})
+}
+
+func hash(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(st storage.Storage, name string) (string, error) {
+	f, err := st.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return hash(f)
+}
+
+// VerifyBlobs checks if the contents of every blob and every indexed path
+// match their recorded sums. Mismatched or missing files are passed to bad.
+func VerifyBlobs(st storage.Storage, bad func(fPath string, err error)) error {
+	blobs, err := st.List(BlobDir)
+	if err != nil {
+		return err
+	}
+
+	for _, blob := range blobs {
+		if blob == IndexPath {
+			continue
+		}
+
+		sum, err := hashFile(st, blob)
+		if err != nil {
+			return err
+		}
+		if sum != path.Base(blob) {
+			bad(blob, fmt.Errorf("content hash is %s", sum))
+		}
+	}
+
+	index, err := ReadIndex(st)
+	if err != nil {
+		return err
+	}
+
+	for fPath, sum := range index {
+		if _, err := st.Stat(blobPath(sum)); err != nil {
+			bad(fPath, err)
+			continue
+		}
+
+		if _, err := st.Stat(fPath); os.IsNotExist(err) {
+			// index-only mapping
+			continue
+		} else if err != nil {
+			bad(fPath, err)
+			continue
+		}
+
+		if got, err := hashFile(st, fPath); err != nil {
+			bad(fPath, err)
+		} else if got != sum {
+			bad(fPath, fmt.Errorf("content hash is %s, expected %s", got, sum))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package cdndl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+	"strings"
+
+	"github.com/tsudoko/pullcord/storage"
+)
+
+// Files with names too long for the filesystem are saved in the same
+// directory as _long_<SHA-256 of the original name>.<extension>. Renames are
+// recorded in LongNamesPath in the "path,storedpath" format.
+const (
+	longNamePrefix = "_long_"
+	maxExtLen      = 16
+	LongNamesPath  = "longnames.tsv"
+)
+
+// longName returns the path fPath is saved to if its name is too long.
+func longName(fPath string) string {
+	dir, name := path.Split(fPath)
+	sum := sha256.Sum256([]byte(name))
+
+	ext := path.Ext(name)
+	if len(ext) > maxExtLen {
+		ext = ""
+	}
+
+	return path.Join(dir, longNamePrefix+hex.EncodeToString(sum[:])+ext)
+}
+
+func isLongName(fPath string) bool {
+	return strings.HasPrefix(path.Base(fPath), longNamePrefix)
+}
+
+func (m *Manager) recordLongName(fPath, stored string) error {
+	return m.appendRecord(LongNamesPath, []string{fPath, stored})
+}
+
+// Resolve returns the path of the stored copy of the file at URL, or an error
+// if it hasn't been downloaded. Files which were only stored in the blob store
+// are looked up in index, as returned by ReadIndex, which can be nil if
+// deduplication wasn't used.
+func Resolve(st storage.Storage, index map[string]string, URL string) (string, error) {
+	fPath, err := LocalPath(URL)
+	if err != nil {
+		return "", err
+	}
+
+	stored, err := ResolvePath(st, index, fPath)
+	if err == nil {
+		return stored, nil
+	}
+	if stored, lerr := ResolvePath(st, index, longName(fPath)); lerr == nil {
+		return stored, nil
+	}
+	return "", err
+}
+
+// ResolvePath is like Resolve for a file recorded at fPath, e.g. in an
+// embedmedia record.
+func ResolvePath(st storage.Storage, index map[string]string, fPath string) (string, error) {
+	_, err := st.Stat(fPath)
+	if err == nil {
+		return fPath, nil
+	}
+	if sum, ok := index[fPath]; ok {
+		return blobPath(sum), nil
+	}
+	return "", err
+}
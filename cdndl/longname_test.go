@@ -0,0 +1,44 @@
+package cdndl
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/tsudoko/pullcord/storage"
+)
+
+func TestResolve(t *testing.T) {
+	st := storage.Dir(t.TempDir())
+	if err := st.Put("attachments/1/2/linked.png", strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	index := map[string]string{
+		"attachments/1/2/linked.png": "aa00",
+		"attachments/1/3/blob.png":   "bb00",
+	}
+	long := "attachments/1/4/" + strings.Repeat("x", 300) + ".png"
+	index[longName(long)] = "cc00"
+
+	tests := []struct {
+		URL, want string
+	}{
+		// links back to the path are preferred
+		{"https://cdn.discordapp.com/attachments/1/2/linked.png", "attachments/1/2/linked.png"},
+		{"https://cdn.discordapp.com/attachments/1/3/blob.png", "blobs/bb/bb00"},
+		{"https://cdn.discordapp.com/" + long, "blobs/cc/cc00"},
+	}
+	for _, tt := range tests {
+		if got, err := Resolve(st, index, tt.URL); err != nil || got != tt.want {
+			t.Errorf("Resolve(%.60q) = %q, %v, want %q", tt.URL, got, err, tt.want)
+		}
+	}
+
+	if _, err := Resolve(st, index, "https://cdn.discordapp.com/attachments/1/5/missing.png"); !os.IsNotExist(err) {
+		t.Errorf("missing file: got %v, want a not exist error", err)
+	}
+	if got, err := Resolve(st, nil, "https://cdn.discordapp.com/attachments/1/2/linked.png"); err != nil || got != "attachments/1/2/linked.png" {
+		t.Errorf("without an index: got %q, %v", got, err)
+	}
+}
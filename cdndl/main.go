@@ -2,133 +2,376 @@ package cdndl
 
 import (
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
+
+	"github.com/tsudoko/pullcord/retry"
 )
 
 const maxSize = "4096"
-const longNamePlaceholder = "_long_filename"
 
 // Returned when the request gets a non-200 HTTP response.
 type ErrNotOk struct {
 	error
 	URL        string
 	StatusCode int
+	RetryAfter time.Duration // from the Retry-After header, 0 if not present
 }
 
 // discordgo uses EndpointAPI, which includes an extra "/api" path element
 var EndpointCDNEmojis = discordgo.EndpointCDN + "emojis/"
 
-func NewErrNotOk(URL string, code int) error {
-	return ErrNotOk{fmt.Errorf("non-200 status code: %d", code), URL, code}
+func NewErrNotOk(URL string, code int, retryAfter time.Duration) error {
+	return ErrNotOk{fmt.Errorf("non-200 status code: %d", code), URL, code, retryAfter}
 }
 
-func absDL(URL string) error {
-	u, err := url.Parse(URL)
-	if err != nil {
-		return err
+func temporary(err error) (bool, time.Duration) {
+	if cerr, ok := err.(ErrNotOk); ok {
+		return retry.TemporaryStatus(cerr.StatusCode), cerr.RetryAfter
 	}
+	if err == errBadPartial {
+		return true, 0
+	}
+	return retry.Temporary(err), 0
+}
 
-	fPath := u.Path[1:]
-	if _, err := os.Stat(fPath); err == nil {
-		return nil
+func isDiscordHost(host string) bool {
+	for _, h := range []string{"discordapp.com", "discordapp.net", "discord.com"} {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+func localPath(u *url.URL) string {
+	// cleaning a rooted path removes leading ".." elements
+	p := path.Clean("/" + u.Path)[1:]
+	if isDiscordHost(u.Hostname()) {
+		return p
 	}
 
-	return absDLTo(URL, fPath)
+	// files from other hosts can only come from embeds without proxy URLs
+	return path.Join("hosts", path.Clean("/" + u.Host)[1:], p)
 }
 
-func absDLTo(URL string, target string) error {
-	log.Printf("downloading %s", URL)
+// LocalPath returns the path a file downloaded from URL is saved to.
+func LocalPath(URL string) (string, error) {
+	u, err := url.Parse(URL)
+	if err != nil {
+		return "", err
+	}
+	return localPath(u), nil
+}
 
-	r, err := http.Get(URL)
+func (m *Manager) absDL(URL string) error {
+	u, err := url.Parse(URL)
 	if err != nil {
 		return err
 	}
-	defer r.Body.Close()
 
-	if r.StatusCode != 200 {
-		return NewErrNotOk(URL, r.StatusCode)
+	fPath := localPath(u)
+	if m.exists(fPath) || m.exists(longName(fPath)) {
+		return nil
 	}
 
-	err = saveFile(r.Body, target)
-	if cerr, ok := err.(*os.PathError); ok {
-		if errno, ok := cerr.Err.(syscall.Errno); ok && errno == syscall.ENAMETOOLONG {
-			dir, name := filepath.Split(target)
-			newname := longNamePlaceholder + filepath.Ext(name)
-			log.Printf("warning: %s: file name too long, renaming to %s", name, newname)
-			return absDLTo(URL, filepath.Join(dir, newname))
+	release, ok := m.claim(fPath)
+	if !ok {
+		return nil
+	}
+	defer release()
 
-		}
+	err = m.Retry.Do("downloading "+URL, func() error {
+		return m.absDLTo(u, fPath)
+	}, temporary)
+	if t, _ := temporary(err); t {
+		log.Printf("warning: giving up on %s: %v", URL, err)
+		return m.recordFailed(URL, fPath, err)
 	}
 
 	return err
 }
 
-func saveFile(r io.Reader, fPath string) error {
-	if err := os.MkdirAll(path.Dir(fPath), os.ModeDir|0755); err != nil {
+func (m *Manager) absDLTo(u *url.URL, target string) error {
+	URL := u.String()
+
+	req, err := http.NewRequest("GET", URL, nil)
+	if err != nil {
 		return err
 	}
 
-	tempPath := fPath + ".part"
+	file := m.workPath(target)
+	part := readPartial(file)
+	if part.offset > 0 {
+		log.Printf("resuming %s at %d bytes", URL, part.offset)
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", part.offset))
+		req.Header.Set("If-Range", part.validator())
+	} else {
+		log.Printf("downloading %s", URL)
+	}
 
-	f, err := os.Create(tempPath)
+	r, done, err := m.do(req)
 	if err != nil {
 		return err
 	}
 
-	if _, err := io.Copy(f, r); err != nil {
-		f.Close()
-		return err
+	switch r.StatusCode {
+	case http.StatusOK:
+		part = newPartial(r)
+	case http.StatusPartialContent:
+		if start, size, ok := parseContentRange(r.Header.Get("Content-Range")); !ok || start != part.offset || (part.size >= 0 && size != part.size) {
+			done()
+			removePartial(file)
+			return errBadPartial
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		done()
+		if part.size >= 0 && part.offset == part.size {
+			// the previous run was interrupted right before renaming
+			sum, err := finishPartial(file)
+			if err != nil {
+				return err
+			}
+			return m.finish(u, target, sum, r.StatusCode, "", part)
+		}
+		removePartial(file)
+		return errBadPartial
+	default:
+		done()
+		return NewErrNotOk(URL, r.StatusCode, retry.After(r.Header))
 	}
-	f.Close()
 
-	if err = os.Rename(tempPath, fPath); err != nil {
-		return err
+	sum, err := saveFile(r.Body, file, part)
+	done()
+	if err == nil {
+		return m.finish(u, target, sum, r.StatusCode, r.Header.Get("Content-Type"), part)
+	}
+	if cerr, ok := err.(*os.PathError); ok {
+		if errno, ok := cerr.Err.(syscall.Errno); ok && errno == syscall.ENAMETOOLONG && !isLongName(target) {
+			stored := longName(target)
+			log.Printf("warning: %s: file name too long, saving as %s", target, filepath.Base(stored))
+			if err := m.absDLTo(u, stored); err != nil {
+				return err
+			}
+			return m.recordLongName(target, stored)
+		}
+	}
+
+	return err
+}
+
+// animated returns the GIF version of an image if its hash says it's animated.
+func animated(hash, png, gif string) string {
+	if strings.HasPrefix(hash, "a_") {
+		return gif
 	}
+	return png
+}
+
+// URLs of files downloaded by the Manager methods below, they're also used
+// to describe files for download policies.
+
+func AvatarURL(uid, hash string) string {
+	return animated(hash,
+		discordgo.EndpointUserAvatar(uid, hash),
+		discordgo.EndpointUserAvatarAnimated(uid, hash)) + "?size=" + maxSize
+}
+
+func MemberAvatarURL(gid, uid, hash string) string {
+	return animated(hash,
+		discordgo.EndpointGuildMemberAvatar(gid, uid, hash),
+		discordgo.EndpointGuildMemberAvatarAnimated(gid, uid, hash)) + "?size=" + maxSize
+}
 
-	return nil
+func UserBannerURL(uid, hash string) string {
+	return animated(hash,
+		discordgo.EndpointUserBanner(uid, hash),
+		discordgo.EndpointUserBannerAnimated(uid, hash)) + "?size=" + maxSize
 }
 
-func Avatar(u *discordgo.User) error {
-	return absDL(u.AvatarURL(maxSize))
+func MemberBannerURL(gid, uid, hash string) string {
+	base := discordgo.EndpointCDN + "guilds/" + gid + "/users/" + uid + "/banners/" + hash
+	return animated(hash, base+".png", base+".gif") + "?size=" + maxSize
 }
 
-func Emoji(id string, animated bool) error {
-	var ext string
+func EmojiURL(id string, animated bool) string {
+	ext := "png"
 	if animated {
 		ext = "gif"
-	} else {
-		ext = "png"
 	}
-	err := absDL(fmt.Sprintf("%s%s.%s?size=%s", EndpointCDNEmojis, id, ext, maxSize))
-	if cerr, ok := err.(ErrNotOk); ok && cerr.StatusCode == 415 && ext == "gif" {
+	return fmt.Sprintf("%s%s.%s?size=%s", EndpointCDNEmojis, id, ext, maxSize)
+}
+
+func IconURL(gid, hash string) string {
+	return animated(hash,
+		discordgo.EndpointGuildIcon(gid, hash),
+		discordgo.EndpointGuildIconAnimated(gid, hash)) + "?size=" + maxSize
+}
+
+func BannerURL(gid, hash string) string {
+	return animated(hash,
+		discordgo.EndpointGuildBanner(gid, hash),
+		discordgo.EndpointGuildBannerAnimated(gid, hash)) + "?size=" + maxSize
+}
+
+func DiscoverySplashURL(gid, hash string) string {
+	return discordgo.EndpointCDN + "discovery-splashes/" + gid + "/" + hash + ".png?size=" + maxSize
+}
+
+func RoleIconURL(rid, hash string) string {
+	return discordgo.EndpointRoleIcon(rid, hash) + "?size=" + maxSize
+}
+
+func ChannelIconURL(cid, hash string) string {
+	return discordgo.EndpointGroupIcon(cid, hash) + "?size=" + maxSize
+}
+
+func SplashURL(gid, hash string) string {
+	return discordgo.EndpointGuildSplash(gid, hash) + "?size=" + maxSize
+}
+
+func (m *Manager) Avatar(u *discordgo.User) error {
+	return m.absDL(AvatarURL(u.ID, u.Avatar))
+}
+
+// MemberAvatar downloads a server-specific avatar of a member.
+func (m *Manager) MemberAvatar(gid, uid, hash string) error {
+	return m.absDL(MemberAvatarURL(gid, uid, hash))
+}
+
+func (m *Manager) UserBanner(uid, hash string) error {
+	return m.absDL(UserBannerURL(uid, hash))
+}
+
+// MemberBanner downloads a server-specific banner of a member.
+func (m *Manager) MemberBanner(gid, uid, hash string) error {
+	return m.absDL(MemberBannerURL(gid, uid, hash))
+}
+
+func (m *Manager) Emoji(id string, animated bool) error {
+	err := m.absDL(EmojiURL(id, animated))
+	if cerr, ok := err.(ErrNotOk); ok && cerr.StatusCode == 415 && animated {
 		log.Printf("warning: animated version of emoji %s doesn't exist, trying png", id)
-		ext = "png"
-		err = absDL(fmt.Sprintf("%s%s.%s?size=%s", EndpointCDNEmojis, id, ext, maxSize))
+		err = m.absDL(EmojiURL(id, false))
 	}
 	return err
 }
 
-func Icon(gid, hash string) error {
-	return absDL(discordgo.EndpointGuildIcon(gid, hash) + "?size=" + maxSize)
+// EmojiGuess downloads an emoji which isn't known to be animated or not,
+// trying the animated version first. Nothing is downloaded if either version
+// exists already.
+func (m *Manager) EmojiGuess(id string) error {
+	if m.Downloaded(EmojiURL(id, false)) {
+		return nil
+	}
+	return m.Emoji(id, true)
+}
+
+func (m *Manager) Icon(gid, hash string) error {
+	return m.absDL(IconURL(gid, hash))
+}
+
+func (m *Manager) Banner(gid, hash string) error {
+	return m.absDL(BannerURL(gid, hash))
+}
+
+func (m *Manager) DiscoverySplash(gid, hash string) error {
+	return m.absDL(DiscoverySplashURL(gid, hash))
+}
+
+func (m *Manager) RoleIcon(rid, hash string) error {
+	return m.absDL(RoleIconURL(rid, hash))
+}
+
+func (m *Manager) ChannelIcon(cid, hash string) error {
+	return m.absDL(ChannelIconURL(cid, hash))
+}
+
+func (m *Manager) Splash(gid, hash string) error {
+	return m.absDL(SplashURL(gid, hash))
+}
+
+// Downloaded reports if the file at URL has been downloaded already. Emoji
+// count as downloaded in either of their formats, see EmojiGuess.
+func (m *Manager) Downloaded(URL string) bool {
+	u, err := url.Parse(URL)
+	if err != nil {
+		return false
+	}
+
+	fPath := localPath(u)
+	paths := []string{fPath}
+	if isDiscordHost(u.Hostname()) && path.Dir(fPath) == "emojis" {
+		base := strings.TrimSuffix(fPath, path.Ext(fPath))
+		paths = []string{base + ".png", base + ".gif"}
+	}
+
+	for _, p := range paths {
+		if m.exists(p) || m.exists(longName(p)) {
+			return true
+		}
+	}
+	return false
 }
 
-func ChannelIcon(cid, hash string) error {
-	return absDL(discordgo.EndpointGroupIcon(cid, hash) + "?size=" + maxSize)
+// Attachment downloads an attachment. If m.Refresh is set, the URL is
+// refreshed if its signature is missing or expired, or if the download is
+// denied.
+func (m *Manager) Attachment(URL string) error {
+	u, err := url.Parse(URL)
+	if err != nil {
+		return err
+	}
+
+	fPath := localPath(u)
+	if m.exists(fPath) || m.exists(longName(fPath)) {
+		return nil
+	}
+
+	refreshed := false
+	if m.Refresh != nil && (!isSigned(u) || signatureExpired(u)) {
+		if URL, err = m.refresh(URL); err != nil {
+			return err
+		}
+		refreshed = true
+	}
+
+	err = m.absDL(URL)
+	if cerr, ok := err.(ErrNotOk); ok && m.Refresh != nil && !refreshed && (cerr.StatusCode == 403 || cerr.StatusCode == 404) {
+		if URL, err = m.refresh(URL); err != nil {
+			return err
+		}
+		err = m.absDL(URL)
+	}
+
+	return err
 }
 
-func Splash(gid, hash string) error {
-	return absDL(discordgo.EndpointGuildSplash(gid, hash) + "?size=" + maxSize)
+// EmbedMedia downloads an image or a video referenced by an embed.
+func (m *Manager) EmbedMedia(URL string) error {
+	u, err := url.Parse(URL)
+	if err != nil {
+		return err
+	}
+
+	// embeds can show attachments, which have signed URLs
+	if isDiscordHost(u.Hostname()) && strings.HasPrefix(localPath(u), "attachments/") {
+		return m.Attachment(URL)
+	}
+
+	return m.absDL(URL)
 }
 
-func Attachment(url string) error {
-	return absDL(url)
+// AttachmentURL reconstructs the URL of an attachment.
+func AttachmentURL(cid, id, filename string) string {
+	return discordgo.EndpointCDN + "attachments/" + cid + "/" + id + "/" + url.PathEscape(filename)
 }
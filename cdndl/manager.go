@@ -0,0 +1,277 @@
+package cdndl
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tsudoko/pullcord/logentry"
+	"github.com/tsudoko/pullcord/retry"
+	"github.com/tsudoko/pullcord/storage"
+	"github.com/tsudoko/pullcord/tsv"
+)
+
+// FailedPath, like other paths in this package, is relative to the archive
+// root. It's the list of downloads which couldn't be completed, records
+// are in the "time,url,path,error" format.
+const FailedPath = "failed.tsv"
+
+// Manager downloads files to an archive using a shared HTTP client.
+// Downloads can be queued with Go, they're then run by a bounded pool of
+// workers.
+type Manager struct {
+	Retry  retry.Policy
+	Dedup  bool   // store files in the content-addressed blob store
+	Policy Policy // checked by Allowed
+
+	// WorkDir is a local directory partial downloads are kept in until
+	// they're complete, see NewManager for the default
+	WorkDir string
+
+	// Refresh returns a freshly signed version of an attachment URL, it can
+	// be nil if there's no way to get one
+	Refresh func(URL string) (string, error)
+
+	st      storage.Storage
+	client  *http.Client
+	perHost int
+
+	jobs    chan func() error
+	workers sync.WaitGroup
+	pending sync.WaitGroup
+
+	mu       sync.Mutex
+	hosts    map[string]chan struct{}  // per-host semaphores
+	inflight map[string]chan struct{}  // closed when the download of a path finishes
+	err      error                     // first error returned by a job since the last Wait
+	records  map[string]io.WriteCloser // data files opened by appendRecord
+	index    map[string]string         // blob index, loaded on first use
+	skipped  map[string]bool           // URLs listed in SkippedPath, loaded on first use
+}
+
+func newClient(perHost int) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   perHost,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: time.Minute,
+			ExpectContinueTimeout: time.Second,
+		},
+	}
+}
+
+// NewManager starts a manager which saves files in st, with the given number
+// of workers. No more than perHost requests are made to a single host at the
+// same time. Partial downloads are kept next to complete ones for local
+// storage and in a temporary directory otherwise.
+func NewManager(st storage.Storage, workers, perHost int) *Manager {
+	if workers < 1 {
+		workers = 1
+	}
+	if perHost < 1 {
+		perHost = 1
+	}
+
+	m := &Manager{
+		Retry:    retry.Default,
+		WorkDir:  filepath.Join(os.TempDir(), "pullcord"),
+		st:       st,
+		client:   newClient(perHost),
+		perHost:  perHost,
+		jobs:     make(chan func() error),
+		hosts:    make(map[string]chan struct{}),
+		inflight: make(map[string]chan struct{}),
+		records:  make(map[string]io.WriteCloser),
+	}
+	if d, ok := st.(storage.Dir); ok {
+		m.WorkDir = string(d)
+	}
+
+	m.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go m.work()
+	}
+
+	return m
+}
+
+// workPath returns the location of the partial download of fPath.
+func (m *Manager) workPath(fPath string) string {
+	return filepath.Join(m.WorkDir, filepath.FromSlash(fPath))
+}
+
+func (m *Manager) work() {
+	defer m.workers.Done()
+
+	for job := range m.jobs {
+		if err := job(); err != nil {
+			m.mu.Lock()
+			if m.err == nil {
+				m.err = err
+			}
+			m.mu.Unlock()
+		}
+		m.pending.Done()
+	}
+}
+
+// Go queues a job, it blocks if all workers are busy.
+func (m *Manager) Go(job func() error) {
+	m.pending.Add(1)
+	m.jobs <- job
+}
+
+// Wait waits for all queued jobs to finish and returns the first error
+// returned by any of them.
+func (m *Manager) Wait() error {
+	m.pending.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err := m.err
+	m.err = nil
+	return err
+}
+
+// Close waits for queued jobs and stops all workers. The storage isn't
+// closed.
+func (m *Manager) Close() error {
+	err := m.Wait()
+	close(m.jobs)
+	m.workers.Wait()
+
+	for _, f := range m.records {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// appendRecord appends a record to a data file, which is kept open until
+// Close.
+func (m *Manager) appendRecord(name string, record []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f := m.records[name]
+	if f == nil {
+		var err error
+		if f, err = m.st.Append(name); err != nil {
+			return err
+		}
+		m.records[name] = f
+	}
+
+	return tsv.Write(f, record)
+}
+
+// readRecords calls f for every record of a data file, a missing file has
+// none. Data files aren't rotated, so unlike logfile.Decode, it doesn't look
+// for segments, which would mean listing the whole directory tree.
+func readRecords(st storage.Storage, name string, f func(record []string) error) error {
+	r, err := st.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	dec := tsv.NewDecoder(r)
+	for {
+		record, err := dec.Decode()
+		if err == io.EOF {
+			return nil
+		}
+		if err == nil {
+			err = f(record)
+		}
+
+		if perr, ok := err.(*tsv.ParseError); ok {
+			perr.File = name
+			return perr
+		} else if err != nil {
+			return &tsv.ParseError{File: name, Line: dec.Line(), Err: err}
+		}
+	}
+}
+
+// recordFailed appends a download which failed despite retrying to the
+// failed downloads list, so it can be tried again later.
+func (m *Manager) recordFailed(URL, fPath string, err error) error {
+	return m.appendRecord(FailedPath, []string{logentry.Timestamp(), StripSignature(URL), fPath, err.Error()})
+}
+
+// RecordFailed adds a download which failed for a reason other than a
+// transient error to the failed downloads list.
+func (m *Manager) RecordFailed(URL string, err error) error {
+	fPath, perr := LocalPath(URL)
+	if perr != nil {
+		return perr
+	}
+	return m.recordFailed(URL, fPath, err)
+}
+
+func (m *Manager) acquireHost(host string) (release func()) {
+	m.mu.Lock()
+	sem := m.hosts[host]
+	if sem == nil {
+		sem = make(chan struct{}, m.perHost)
+		m.hosts[host] = sem
+	}
+	m.mu.Unlock()
+
+	sem <- struct{}{}
+	return func() { <-sem }
+}
+
+// claim marks fPath as being downloaded. If another worker is already
+// downloading it, claim waits until it's done and returns false.
+func (m *Manager) claim(fPath string) (release func(), ok bool) {
+	m.mu.Lock()
+	if done, busy := m.inflight[fPath]; busy {
+		m.mu.Unlock()
+		<-done
+		return nil, false
+	}
+
+	done := make(chan struct{})
+	m.inflight[fPath] = done
+	m.mu.Unlock()
+
+	return func() {
+		m.mu.Lock()
+		delete(m.inflight, fPath)
+		m.mu.Unlock()
+		close(done)
+	}, true
+}
+
+func (m *Manager) do(req *http.Request) (*http.Response, func(), error) {
+	release := m.acquireHost(req.URL.Host)
+
+	r, err := m.client.Do(req)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	return r, func() {
+		// drain the body so the connection can be reused
+		io.Copy(io.Discard, r.Body)
+		r.Body.Close()
+		release()
+	}, nil
+}
@@ -0,0 +1,86 @@
+package cdndl
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tsudoko/pullcord/storage"
+	"github.com/tsudoko/pullcord/tsv"
+)
+
+// noList is a storage which can't be listed, listing a bucket can take
+// longer than the rest of a pull.
+type noList struct {
+	storage.Storage
+	t *testing.T
+}
+
+func (s noList) List(dir string) ([]string, error) {
+	s.t.Errorf("listed %s", dir)
+	return nil, errors.New("not listable")
+}
+
+func TestReadDataFiles(t *testing.T) {
+	dir := storage.Dir(t.TempDir())
+	st := noList{dir, t}
+
+	// missing files are empty
+	if index, err := ReadIndex(st); err != nil || len(index) != 0 {
+		t.Errorf("missing index: got %v, %v", index, err)
+	}
+	if entries, err := ReadManifest(st); err != nil || len(entries) != 0 {
+		t.Errorf("missing manifest: got %v, %v", entries, err)
+	}
+	if skipped, err := readSkipped(st); err != nil || len(skipped) != 0 {
+		t.Errorf("missing skipped list: got %v, %v", skipped, err)
+	}
+
+	put := func(name, data string) {
+		t.Helper()
+		if err := dir.Put(name, strings.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put(IndexPath, "attachments/1/2/a.png\taa00\nattachments/1/2/b.png\tbb00\n")
+	put(ManifestPath, "t\thttps://cdn.discordapp.com/a\ta\t1\taa00\timage/png\t\t\t200\nt\tu\tb\tbad\n")
+
+	index, err := ReadIndex(st)
+	if err != nil || len(index) != 2 || index["attachments/1/2/b.png"] != "bb00" {
+		t.Errorf("index: got %v, %v", index, err)
+	}
+
+	_, err = ReadManifest(st)
+	var perr *tsv.ParseError
+	if !errors.As(err, &perr) || perr.File != ManifestPath || perr.Line != 2 {
+		t.Errorf("manifest: got %v, want an error for line 2", err)
+	}
+}
+
+func TestRecordFailed(t *testing.T) {
+	st := storage.Dir(t.TempDir())
+	m := NewManager(st, 1, 1)
+	URL := "https://cdn.discordapp.com/attachments/1/2/a.png?ex=1&is=2&hm=3"
+	if err := m.RecordFailed(URL, errors.New("unsupported")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var records [][]string
+	err := readRecords(st, FailedPath, func(record []string) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || len(records[0]) != 4 {
+		t.Fatalf("got %q", records)
+	}
+	want := []string{"https://cdn.discordapp.com/attachments/1/2/a.png", "attachments/1/2/a.png", "unsupported"}
+	if got := records[0][1:]; strings.Join(got, "\t") != strings.Join(want, "\t") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,145 @@
+package cdndl
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/tsudoko/pullcord/logentry"
+	"github.com/tsudoko/pullcord/storage"
+)
+
+// ManifestPath is the list of all completed downloads, a record is appended
+// after each one, see ManifestEntry for the fields.
+const ManifestPath = "manifest.tsv"
+
+type ManifestEntry struct {
+	Time         string
+	URL          string
+	Path         string // local path, relative to the archive root
+	Size         int64
+	SHA256       string
+	ContentType  string
+	LastModified string
+	ETag         string
+	StatusCode   int
+}
+
+func (e *ManifestEntry) record() []string {
+	return []string{
+		e.Time,
+		e.URL,
+		e.Path,
+		strconv.FormatInt(e.Size, 10),
+		e.SHA256,
+		e.ContentType,
+		e.LastModified,
+		e.ETag,
+		strconv.Itoa(e.StatusCode),
+	}
+}
+
+func parseManifestEntry(r []string) (*ManifestEntry, error) {
+	if len(r) < 9 {
+		return nil, fmt.Errorf("expected 9 fields, got %d", len(r))
+	}
+
+	size, err := strconv.ParseInt(r[3], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := strconv.Atoi(r[8])
+	if err != nil {
+		return nil, err
+	}
+
+	return &ManifestEntry{r[0], r[1], r[2], size, r[4], r[5], r[6], r[7], status}, nil
+}
+
+// finish moves a complete download to the storage, or the blob store if
+// deduplication is enabled, and records it.
+func (m *Manager) finish(u *url.URL, fPath, sum string, status int, contentType string, p partial) error {
+	fi, err := os.Stat(m.workPath(fPath) + partSuffix)
+	if err != nil {
+		return err
+	}
+
+	if m.Dedup {
+		err = m.store(fPath, sum)
+	} else {
+		err = m.commit(fPath, fPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	e := &ManifestEntry{
+		Time:         logentry.Timestamp(),
+		URL:          StripSignature(u.String()),
+		Path:         fPath,
+		Size:         fi.Size(),
+		SHA256:       sum,
+		ContentType:  contentType,
+		LastModified: p.lastModified,
+		ETag:         p.etag,
+		StatusCode:   status,
+	}
+	return m.appendRecord(ManifestPath, e.record())
+}
+
+// ReadManifest returns the latest manifest entry for each local path.
+func ReadManifest(st storage.Storage) (map[string]*ManifestEntry, error) {
+	entries := make(map[string]*ManifestEntry)
+	err := readRecords(st, ManifestPath, func(record []string) error {
+		e, err := parseManifestEntry(record)
+		if err != nil {
+			return err
+		}
+		entries[e.Path] = e
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// VerifyManifest checks if the size and the hash of every file in the
+// manifest match. Mismatched or missing files are passed to bad.
+func VerifyManifest(st storage.Storage, bad func(fPath string, err error)) error {
+	entries, err := ReadManifest(st)
+	if err != nil {
+		return err
+	}
+
+	index, err := ReadIndex(st)
+	if err != nil {
+		return err
+	}
+
+	for fPath, e := range entries {
+		size, err := st.Stat(fPath)
+		if os.IsNotExist(err) && index[fPath] != "" {
+			// index-only mapping, checked by VerifyBlobs
+			continue
+		} else if err != nil {
+			bad(fPath, err)
+			continue
+		}
+
+		if size != e.Size {
+			bad(fPath, fmt.Errorf("size is %d, expected %d", size, e.Size))
+			continue
+		}
+
+		if sum, err := hashFile(st, fPath); err != nil {
+			bad(fPath, err)
+		} else if sum != e.SHA256 {
+			bad(fPath, fmt.Errorf("content hash is %s, expected %s", sum, e.SHA256))
+		}
+	}
+
+	return nil
+}
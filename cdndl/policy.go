@@ -0,0 +1,286 @@
+package cdndl
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/tsudoko/pullcord/logentry"
+	"github.com/tsudoko/pullcord/storage"
+	"github.com/tsudoko/pullcord/tsv"
+)
+
+// PolicyPath contains download policy rules, one per record, in the
+// "action,kind,guildid,channelid,contenttype,largerthan" format. Empty
+// records and records starting with # are ignored.
+//
+// Action is "skip" or "download". Kind is one of the Kind* constants. Content
+// types ending with "/" match all subtypes. Largerthan is a size in bytes,
+// optionally followed by K, M or G. Empty fields match everything. The first
+// matching rule applies, files not matched by any rule are downloaded.
+//
+// Sizes and content types of files other than attachments are requested with
+// HEAD requests, only if a rule needs them. Files whose size can't be
+// determined don't match rules with a size.
+const PolicyPath = "policy.tsv"
+
+// SkippedPath lists files skipped because of a download policy, in the
+// "time,kind,guildid,channelid,url,size,contenttype" format. Every URL is
+// listed once.
+const SkippedPath = "skipped.tsv"
+
+const (
+	KindAvatar     = "avatar" // user avatars and banners
+	KindIcon       = "icon"   // server, channel and role images
+	KindEmoji      = "emoji"
+	KindAttachment = "attachment"
+	KindEmbed      = "embed"
+)
+
+// Item describes a file for the purpose of download policies.
+type Item struct {
+	Kind        string
+	GuildID     string
+	ChannelID   string
+	URL         string
+	ContentType string
+	Size        int64 // -1 if unknown
+}
+
+type Rule struct {
+	Skip        bool
+	Kind        string
+	GuildID     string
+	ChannelID   string
+	ContentType string
+	LargerThan  int64
+}
+
+type Policy []Rule
+
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		mult = 1 << 30
+	}
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseUint(s, 10, 63)
+	if err != nil {
+		return 0, err
+	}
+	if int64(n) > math.MaxInt64/mult {
+		return 0, strconv.ErrRange
+	}
+	return int64(n) * mult, nil
+}
+
+func parseRule(r []string) (Rule, error) {
+	for len(r) < 6 {
+		r = append(r, "")
+	}
+
+	var rule Rule
+	switch r[0] {
+	case "skip":
+		rule.Skip = true
+	case "download":
+	default:
+		return rule, fmt.Errorf("invalid action %q", r[0])
+	}
+
+	switch r[1] {
+	case "", KindAvatar, KindIcon, KindEmoji, KindAttachment, KindEmbed:
+		rule.Kind = r[1]
+	default:
+		return rule, fmt.Errorf("invalid kind %q", r[1])
+	}
+
+	rule.GuildID = r[2]
+	rule.ChannelID = r[3]
+	rule.ContentType = r[4]
+
+	if r[5] != "" {
+		size, err := parseSize(r[5])
+		if err != nil {
+			return rule, fmt.Errorf("invalid size %q", r[5])
+		}
+		rule.LargerThan = size
+	}
+
+	return rule, nil
+}
+
+// ReadPolicy reads the download policy of the archive, a missing policy file
+// means everything is downloaded.
+func ReadPolicy(st storage.Storage) (Policy, error) {
+	f, err := st.Open(PolicyPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var p Policy
+	dec := tsv.NewDecoder(f)
+	for {
+		e, err := dec.Decode()
+		if err == io.EOF {
+			break
+		} else if perr, ok := err.(*tsv.ParseError); ok {
+			perr.File = PolicyPath
+			return nil, perr
+		} else if err != nil {
+			return nil, err
+		}
+
+		if t := strings.TrimSpace(dec.Raw()); t == "" || strings.HasPrefix(t, "#") {
+			continue
+		}
+
+		rule, err := parseRule(e)
+		if err != nil {
+			return nil, &tsv.ParseError{File: PolicyPath, Line: dec.Line(), Err: err}
+		}
+		p = append(p, rule)
+	}
+
+	return p, nil
+}
+
+func (r *Rule) needsMetadata() bool {
+	return r.ContentType != "" || r.LargerThan != 0
+}
+
+func (r *Rule) matches(it *Item) bool {
+	if (r.Kind != "" && r.Kind != it.Kind) ||
+		(r.GuildID != "" && r.GuildID != it.GuildID) ||
+		(r.ChannelID != "" && r.ChannelID != it.ChannelID) {
+		return false
+	}
+
+	if r.ContentType != "" {
+		ct := strings.TrimSpace(strings.SplitN(it.ContentType, ";", 2)[0])
+		if strings.HasSuffix(r.ContentType, "/") {
+			if !strings.HasPrefix(ct, r.ContentType) {
+				return false
+			}
+		} else if ct != r.ContentType {
+			return false
+		}
+	}
+
+	return r.LargerThan == 0 || (it.Size >= 0 && it.Size > r.LargerThan)
+}
+
+// head fills in the size and the content type of an item.
+func (m *Manager) head(it *Item) error {
+	req, err := http.NewRequest("HEAD", it.URL, nil)
+	if err != nil {
+		return err
+	}
+
+	r, done, err := m.do(req)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	if r.StatusCode != 200 {
+		return NewErrNotOk(it.URL, r.StatusCode, 0)
+	}
+
+	if it.ContentType == "" {
+		it.ContentType = r.Header.Get("Content-Type")
+	}
+	if it.Size < 0 {
+		it.Size = r.ContentLength
+	}
+	return nil
+}
+
+// Allowed reports if m.Policy allows downloading an item. Skipped items are
+// recorded in SkippedPath. Callers should check if the item was downloaded
+// already first, see Downloaded.
+func (m *Manager) Allowed(it *Item) bool {
+	headDone := false
+	for i := range m.Policy {
+		r := &m.Policy[i]
+
+		if r.needsMetadata() && !headDone && it.URL != "" && (it.Size < 0 || it.ContentType == "") {
+			headDone = true
+			if err := m.head(it); err != nil {
+				log.Printf("warning: getting size of %s failed: %v", StripSignature(it.URL), err)
+			}
+		}
+
+		if !r.matches(it) {
+			continue
+		}
+
+		if r.Skip {
+			if err := m.recordSkipped(it); err != nil {
+				log.Printf("warning: recording skipped file failed: %v", err)
+			}
+		}
+		return !r.Skip
+	}
+
+	return true
+}
+
+func (m *Manager) recordSkipped(it *Item) error {
+	URL := StripSignature(it.URL)
+
+	m.mu.Lock()
+	if m.skipped == nil {
+		skipped, err := readSkipped(m.st)
+		if err != nil {
+			m.mu.Unlock()
+			return err
+		}
+		m.skipped = skipped
+	}
+	recorded := m.skipped[URL]
+	m.skipped[URL] = true
+	m.mu.Unlock()
+
+	if recorded {
+		return nil
+	}
+
+	return m.appendRecord(SkippedPath, []string{
+		logentry.Timestamp(),
+		it.Kind,
+		it.GuildID,
+		it.ChannelID,
+		URL,
+		strconv.FormatInt(it.Size, 10),
+		it.ContentType,
+	})
+}
+
+// readSkipped returns the URLs listed in SkippedPath.
+func readSkipped(st storage.Storage) (map[string]bool, error) {
+	skipped := make(map[string]bool)
+	err := readRecords(st, SkippedPath, func(e []string) error {
+		if len(e) > 4 {
+			skipped[e[4]] = true
+		}
+		return nil
+	})
+	return skipped, err
+}
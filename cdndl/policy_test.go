@@ -0,0 +1,107 @@
+package cdndl
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tsudoko/pullcord/storage"
+	"github.com/tsudoko/pullcord/tsv"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		r    string
+		want Rule
+		ok   bool
+	}{
+		{"skip", Rule{Skip: true}, true},
+		{"download", Rule{}, true},
+		{"skip\tattachment\t1\t2\tvideo/\t10M", Rule{true, KindAttachment, "1", "2", "video/", 10 << 20}, true},
+		{"skip\t\t\t\t\t100", Rule{Skip: true, LargerThan: 100}, true},
+		{"skip\t\t\t\t\t1K", Rule{Skip: true, LargerThan: 1 << 10}, true},
+		{"skip\t\t\t\t\t2G", Rule{Skip: true, LargerThan: 2 << 30}, true},
+		{"download\temoji\t\t\timage/gif", Rule{Kind: KindEmoji, ContentType: "image/gif"}, true},
+		// extra fields are ignored
+		{"skip\tembed\t\t\t\t\textra", Rule{Skip: true, Kind: KindEmbed}, true},
+
+		{"", Rule{}, false},
+		{"Skip", Rule{}, false},
+		{"skip\tsticker", Rule{}, false},
+		{"skip\t\t\t\t\t10T", Rule{}, false},
+		{"skip\t\t\t\t\tK", Rule{}, false},
+		{"skip\t\t\t\t\t-1", Rule{}, false},
+		{"skip\t\t\t\t\t1.5M", Rule{}, false},
+		{"skip\t\t\t\t\t9999999999999G", Rule{}, false},
+	}
+	for _, tt := range tests {
+		got, err := parseRule(strings.Split(tt.r, "\t"))
+		if (err == nil) != tt.ok || (tt.ok && got != tt.want) {
+			t.Errorf("parseRule(%q) = %+v, %v, want %+v", tt.r, got, err, tt.want)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	attachment := Item{Kind: KindAttachment, GuildID: "1", ChannelID: "2", ContentType: "video/mp4; codecs=avc1", Size: 20 << 20}
+	unknown := Item{Kind: KindEmbed, GuildID: "1", ChannelID: "3", Size: -1}
+
+	tests := []struct {
+		rule Rule
+		it   Item
+		want bool
+	}{
+		{Rule{}, attachment, true},
+		{Rule{}, unknown, true},
+		{Rule{Kind: KindAttachment}, attachment, true},
+		{Rule{Kind: KindEmbed}, attachment, false},
+		{Rule{GuildID: "1", ChannelID: "2"}, attachment, true},
+		{Rule{GuildID: "9"}, attachment, false},
+		{Rule{ChannelID: "3"}, attachment, false},
+
+		// parameters are ignored, "/" matches all subtypes
+		{Rule{ContentType: "video/mp4"}, attachment, true},
+		{Rule{ContentType: "video/"}, attachment, true},
+		{Rule{ContentType: "video"}, attachment, false},
+		{Rule{ContentType: "image/"}, attachment, false},
+		{Rule{ContentType: "video/"}, unknown, false},
+
+		{Rule{LargerThan: 10 << 20}, attachment, true},
+		{Rule{LargerThan: 20 << 20}, attachment, false},
+		{Rule{LargerThan: 1}, unknown, false},
+		{Rule{Kind: KindAttachment, ContentType: "video/", LargerThan: 10 << 20}, attachment, true},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.matches(&tt.it); got != tt.want {
+			t.Errorf("%+v matches %+v = %v, want %v", tt.rule, tt.it, got, tt.want)
+		}
+	}
+}
+
+func TestReadPolicy(t *testing.T) {
+	st := storage.Dir(t.TempDir())
+	if p, err := ReadPolicy(st); err != nil || p != nil {
+		t.Errorf("missing policy: got %v, %v", p, err)
+	}
+
+	policy := "# comment\n\nskip\tattachment\t\t\t\t1M\n  \ndownload\n"
+	if err := st.Put(PolicyPath, strings.NewReader(policy)); err != nil {
+		t.Fatal(err)
+	}
+	p, err := ReadPolicy(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Policy{{Skip: true, Kind: KindAttachment, LargerThan: 1 << 20}, {}}); len(p) != 2 || p[0] != want[0] || p[1] != want[1] {
+		t.Errorf("got %+v, want %+v", p, want)
+	}
+
+	if err := st.Put(PolicyPath, strings.NewReader("# comment\nskip\nskip\tnothing\n")); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ReadPolicy(st)
+	var perr *tsv.ParseError
+	if !errors.As(err, &perr) || perr.File != PolicyPath || perr.Line != 3 {
+		t.Errorf("invalid rule: got %v, want an error for line 3", err)
+	}
+}
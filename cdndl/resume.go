@@ -0,0 +1,214 @@
+package cdndl
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tsudoko/pullcord/tsv"
+)
+
+// Files are downloaded to <path>.part first. Validators of the response are
+// stored in <path>.part.meta in the "etag,lastmodified,size" format, so an
+// interrupted download can be resumed with a Range request if the file
+// hasn't changed since.
+const (
+	partSuffix     = ".part"
+	partMetaSuffix = ".part.meta"
+)
+
+// Returned when a partial download can't be resumed, the partial file is
+// removed in that case, so the download should be retried from scratch.
+var errBadPartial = errors.New("partial download can't be resumed")
+
+type partial struct {
+	offset       int64 // size of the partial file
+	etag         string
+	lastModified string
+	size         int64 // size of the whole file, -1 if unknown
+}
+
+func newPartial(r *http.Response) partial {
+	return partial{0, r.Header.Get("ETag"), r.Header.Get("Last-Modified"), r.ContentLength}
+}
+
+// validator returns the value of the If-Range header, weak ETags aren't
+// allowed there.
+func (p partial) validator() string {
+	if p.etag != "" && !strings.HasPrefix(p.etag, "W/") {
+		return p.etag
+	}
+	return p.lastModified
+}
+
+// readPartial returns the state of a previous download of fPath. Partial
+// files which can't be resumed are removed.
+func readPartial(fPath string) partial {
+	none := partial{size: -1}
+
+	fi, err := os.Stat(fPath + partSuffix)
+	if err != nil {
+		return none
+	}
+
+	var p partial
+	f, err := os.Open(fPath + partMetaSuffix)
+	if err == nil {
+		p, err = readPartialMeta(f)
+		f.Close()
+	}
+
+	if err != nil || p.validator() == "" || (p.size >= 0 && fi.Size() > p.size) {
+		log.Printf("warning: %s: removing partial download which can't be resumed", fPath)
+		removePartial(fPath)
+		return none
+	}
+
+	p.offset = fi.Size()
+	return p
+}
+
+func readPartialMeta(r io.Reader) (partial, error) {
+	e, err := tsv.NewDecoder(r).Decode()
+	if err == io.EOF {
+		return partial{}, errors.New("empty partial download metadata")
+	} else if err != nil {
+		return partial{}, err
+	}
+
+	if len(e) < 3 {
+		return partial{}, errors.New("invalid partial download metadata")
+	}
+
+	size, err := strconv.ParseInt(e[2], 10, 64)
+	if err != nil {
+		return partial{}, err
+	}
+
+	return partial{0, e[0], e[1], size}, nil
+}
+
+func removePartial(fPath string) {
+	os.Remove(fPath + partSuffix)
+	os.Remove(fPath + partMetaSuffix)
+}
+
+// parseContentRange parses a "bytes start-end/size" Content-Range header,
+// size is -1 if unknown. Unsatisfied ranges ("bytes */size") and ranges
+// which don't fit in the file aren't valid.
+func parseContentRange(h string) (start, size int64, ok bool) {
+	rng, ok := strings.CutPrefix(h, "bytes ")
+	if !ok {
+		return 0, 0, false
+	}
+	rng, sizeStr, ok := strings.Cut(rng, "/")
+	if !ok {
+		return 0, 0, false
+	}
+	startStr, endStr, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, 0, false
+	}
+
+	start, ok = parseOffset(startStr)
+	end, ok2 := parseOffset(endStr)
+	if !ok || !ok2 || end < start {
+		return 0, 0, false
+	}
+
+	if sizeStr == "*" {
+		return start, -1, true
+	}
+
+	size, ok = parseOffset(sizeStr)
+	if !ok || end >= size {
+		return 0, 0, false
+	}
+	return start, size, true
+}
+
+// parseOffset parses a byte offset, which can't have a sign.
+func parseOffset(s string) (int64, bool) {
+	n, err := strconv.ParseUint(s, 10, 63)
+	return int64(n), err == nil
+}
+
+// saveFile writes r to the partial file of fPath, appending to it if p.offset
+// is non-zero, and returns the hex-encoded SHA-256 sum of the whole file.
+func saveFile(r io.Reader, fPath string, p partial) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(fPath), os.ModeDir|0755); err != nil {
+		return "", err
+	}
+
+	tempPath := fPath + partSuffix
+	flags := os.O_CREATE | os.O_WRONLY
+	if p.offset > 0 {
+		flags |= os.O_APPEND
+	} else {
+		flags |= os.O_TRUNC
+
+		meta, err := os.Create(fPath + partMetaSuffix)
+		if err != nil {
+			return "", err
+		}
+		err = tsv.Write(meta, []string{p.etag, p.lastModified, strconv.FormatInt(p.size, 10)})
+		meta.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	f, err := os.OpenFile(tempPath, flags, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	n, err := io.Copy(f, r)
+	f.Close()
+	if err != nil {
+		// the partial file is kept for resuming
+		return "", err
+	}
+
+	p.offset += n
+	if p.size >= 0 && p.offset != p.size {
+		return "", io.ErrUnexpectedEOF
+	}
+
+	return finishPartial(fPath)
+}
+
+// finishPartial hashes a complete partial file.
+func finishPartial(fPath string) (string, error) {
+	f, err := os.Open(fPath + partSuffix)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return hash(f)
+}
+
+// commit moves the complete partial download of fPath to name in the storage.
+func (m *Manager) commit(fPath, name string) error {
+	file := m.workPath(fPath)
+
+	f, err := os.Open(file + partSuffix)
+	if err != nil {
+		return err
+	}
+
+	err = m.st.Put(name, f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	removePartial(file)
+	return nil
+}
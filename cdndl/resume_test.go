@@ -0,0 +1,167 @@
+package cdndl
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tsudoko/pullcord/storage"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		h           string
+		start, size int64
+		ok          bool
+	}{
+		{"bytes 0-9/10", 0, 10, true},
+		{"bytes 6-10/11", 6, 11, true},
+		{"bytes 6-10/*", 6, -1, true},
+		{"bytes 5-5/6", 5, 6, true},
+
+		{"bytes */10", 0, 0, false},
+		{"bytes */*", 0, 0, false},
+		{"bytes 6-10/10", 0, 0, false},
+		{"bytes 6-5/10", 0, 0, false},
+		{"bytes -1-5/10", 0, 0, false},
+		{"bytes +1-5/10", 0, 0, false},
+		{"bytes 1-5/-10", 0, 0, false},
+		{"bytes 1-5/10 ", 0, 0, false},
+		{"bytes 1-5/10x", 0, 0, false},
+		{"bytes 1-5", 0, 0, false},
+		{"bytes 1/10", 0, 0, false},
+		{"bytes=1-5/10", 0, 0, false},
+		{"items 1-5/10", 0, 0, false},
+		{"bytes 99999999999999999999-1/2", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, size, ok := parseContentRange(tt.h)
+		if start != tt.start || size != tt.size || ok != tt.ok {
+			t.Errorf("parseContentRange(%q) = %d, %d, %v, want %d, %d, %v", tt.h, start, size, ok, tt.start, tt.size, tt.ok)
+		}
+	}
+}
+
+// writePartial leaves a partial download of fPath with the given contents
+// and metadata, an empty meta means there's none.
+func writePartial(t *testing.T, fPath, data, meta string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(fPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fPath+partSuffix, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if meta != "" {
+		if err := os.WriteFile(fPath+partMetaSuffix, []byte(meta), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadPartial(t *testing.T) {
+	tests := []struct {
+		describe string
+		data     string
+		meta     string
+		want     partial
+	}{
+		{"etag", "hello", "\"e\"\t\t11\n", partial{5, `"e"`, "", 11}},
+		{"last modified", "hello", "W/\"e\"\tMon, 02 Jan 2006 15:04:05 GMT\t-1\n", partial{5, `W/"e"`, "Mon, 02 Jan 2006 15:04:05 GMT", -1}},
+		{"complete", "hello", "\"e\"\t\t5\n", partial{5, `"e"`, "", 5}},
+		{"larger than the file", "hello world", "\"e\"\t\t5\n", partial{size: -1}},
+		{"no metadata", "hello", "", partial{size: -1}},
+		{"empty metadata", "hello", "\n", partial{size: -1}},
+		{"short metadata", "hello", "\"e\"\t\n", partial{size: -1}},
+		{"invalid size", "hello", "\"e\"\t\tbig\n", partial{size: -1}},
+		{"weak etag only", "hello", "W/\"e\"\t\t11\n", partial{size: -1}},
+	}
+	for _, tt := range tests {
+		fPath := filepath.Join(t.TempDir(), "a.png")
+		writePartial(t, fPath, tt.data, tt.meta)
+
+		got := readPartial(fPath)
+		if got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.describe, got, tt.want)
+		}
+
+		_, err := os.Stat(fPath + partSuffix)
+		if kept := err == nil; kept != (tt.want.offset > 0) {
+			t.Errorf("%s: partial file kept: %v", tt.describe, kept)
+		}
+	}
+
+	if got := readPartial(filepath.Join(t.TempDir(), "missing.png")); got != (partial{size: -1}) {
+		t.Errorf("missing: got %+v", got)
+	}
+}
+
+func TestResume(t *testing.T) {
+	tests := []struct {
+		contentRange string
+		err          error
+	}{
+		{"bytes 6-10/11", nil},
+		{"bytes 0-4/11", errBadPartial},
+		// the size has to match the one of the partial download
+		{"bytes 6-10/*", errBadPartial},
+		{"bytes 6-10/12", errBadPartial},
+		{"bytes */11", errBadPartial},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Range") != "bytes=6-" || r.Header.Get("If-Range") != `"e"` {
+				t.Errorf("%s: got Range %q, If-Range %q", tt.contentRange, r.Header.Get("Range"), r.Header.Get("If-Range"))
+			}
+			w.Header().Set("Content-Range", tt.contentRange)
+			w.WriteHeader(http.StatusPartialContent)
+			io.WriteString(w, "world")
+		}))
+
+		st := storage.Dir(t.TempDir())
+		m := NewManager(st, 1, 1)
+		const target = "hosts/test/a.txt"
+		writePartial(t, m.workPath(target), "hello ", "\"e\"\t\t11\n")
+
+		u, _ := url.Parse(srv.URL + "/a.txt")
+		err := m.absDLTo(u, target)
+		srv.Close()
+		if err != tt.err {
+			t.Errorf("%s: got %v, want %v", tt.contentRange, err, tt.err)
+		}
+
+		if tt.err != nil {
+			if _, err := os.Stat(m.workPath(target) + partSuffix); !os.IsNotExist(err) {
+				t.Errorf("%s: partial download wasn't removed", tt.contentRange)
+			}
+		} else if b, err := os.ReadFile(filepath.Join(string(st), filepath.FromSlash(target))); err != nil || string(b) != "hello world" {
+			t.Errorf("%s: got %q, %v", tt.contentRange, b, err)
+		}
+
+		if err := m.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestValidator(t *testing.T) {
+	tests := []struct {
+		p    partial
+		want string
+	}{
+		{partial{etag: `"e"`, lastModified: "lm"}, `"e"`},
+		{partial{etag: `W/"e"`, lastModified: "lm"}, "lm"},
+		{partial{lastModified: "lm"}, "lm"},
+		{partial{etag: `W/"e"`}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.p.validator(); got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.p, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package cdndl
+
+import (
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Attachment URLs are signed with the following query parameters, ex being
+// the expiry time as a hexadecimal Unix timestamp. Attachments can't be
+// downloaded with expired or missing signatures.
+var signatureParams = []string{"ex", "is", "hm"}
+
+func isSigned(u *url.URL) bool {
+	q := u.Query()
+	for _, p := range signatureParams {
+		if q.Get(p) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func signatureExpired(u *url.URL) bool {
+	ex, err := strconv.ParseInt(u.Query().Get("ex"), 16, 64)
+	return err != nil || time.Now().Unix() >= ex
+}
+
+// StripSignature removes signature parameters from a URL, so it doesn't
+// change between fetches.
+func StripSignature(URL string) string {
+	u, err := url.Parse(URL)
+	if err != nil {
+		return URL
+	}
+
+	q := u.Query()
+	for _, p := range signatureParams {
+		q.Del(p)
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+func (m *Manager) refresh(URL string) (string, error) {
+	log.Printf("refreshing signature of %s", StripSignature(URL))
+	return m.Refresh(URL)
+}
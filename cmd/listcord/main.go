@@ -12,16 +12,15 @@ import (
 )
 
 var (
-	username = flag.String("user", "", "email address")
-	password = flag.String("pass", "", "password")
-	token    = flag.String("t", "", "access token")
-	summary  = flag.Bool("s", false, "don't list channels")
+	token   = flag.String("t", "", "access token")
+	bot     = flag.Bool("bot", false, "the token given by -t is a bot token")
+	summary = flag.Bool("s", false, "don't list channels")
 )
 
 func list(d *discordgo.Session, event *discordgo.Ready) {
 	gid := ""
 	for {
-		guilds, err := d.UserGuilds(100, "", gid)
+		guilds, err := d.UserGuilds(100, "", gid, false)
 		if err != nil {
 			log.Fatal("error getting guilds:", err)
 		}
@@ -69,10 +68,9 @@ func list(d *discordgo.Session, event *discordgo.Ready) {
 	}
 	{
 		fmt.Println("@me")
-		c, err := d.UserChannels()
-		if err != nil {
-			log.Fatal("error getting dm channels:", err)
-		}
+		d.State.RLock()
+		c := d.State.PrivateChannels
+		d.State.RUnlock()
 
 		for _, i := range c {
 			var symbol string
@@ -109,7 +107,15 @@ exit:
 func main() {
 	flag.Parse()
 
-	d, err := discordgo.New(*username, *password, *token)
+	if *token == "" {
+		log.Fatal("no token given, see -t")
+	}
+	t := *token
+	if *bot {
+		t = "Bot " + t
+	}
+
+	d, err := discordgo.New(t)
 	if err != nil {
 		log.Fatal("login failed:", err)
 	}
@@ -121,10 +127,6 @@ func main() {
 		log.Fatal("opening the websocket connection failed:", err)
 	}
 
-	if *token == "" {
-		log.Println("token:", d.Token)
-	}
-
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
 	<-sc
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/tsudoko/pullcord/archive"
+	"github.com/tsudoko/pullcord/htmlexport"
+)
+
+func exportHTML(args []string) {
+	fs := flag.NewFlagSet("export html", flag.ExitOnError)
+	root := fs.String("o", ".", "archive directory, .tar file or s3://bucket/prefix URL")
+	gids := fs.String("s", "", "comma-separated server IDs to export (default: all), @me for DMs")
+	dir := fs.String("dir", htmlexport.DefaultDir, "directory to store the pages in, relative to the archive")
+	perPage := fs.Int("per-page", 500, "number of messages per page")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: pullcord export html [options]")
+		fmt.Fprintln(fs.Output(), "Renders channel logs into static HTML pages linking to the downloaded files.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	a, err := archive.Open(*root)
+	if err != nil {
+		log.Fatal("opening the archive failed: ", err)
+	}
+
+	e := htmlexport.New(a)
+	e.Dir = *dir
+	e.PerPage = *perPage
+
+	if *gids == "" {
+		err = e.Export()
+	} else {
+		var ids []string
+		for id := range makeWanted(*gids) {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		err = e.ExportGuilds(ids)
+	}
+	// pages written before an error are kept, see do
+	ok := err == nil
+	if err != nil {
+		log.Print("exporting failed: ", err)
+	}
+
+	if err := a.Close(); err != nil {
+		log.Print("error closing the archive: ", err)
+		ok = false
+	}
+
+	if !ok {
+		os.Exit(1)
+	}
+	log.Printf("pages written to %s", *dir)
+}
+
+var exportFormats = map[string]func(args []string){
+	"html": exportHTML,
+}
+
+func export(args []string) {
+	if len(args) == 0 || exportFormats[args[0]] == nil {
+		fmt.Fprintln(os.Stderr, "usage: pullcord export html [options]")
+		os.Exit(2)
+	}
+	exportFormats[args[0]](args[1:])
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/tsudoko/pullcord/cdndl"
+	"github.com/tsudoko/pullcord/logpull"
+	"github.com/tsudoko/pullcord/retry"
+	"github.com/tsudoko/pullcord/storage"
+)
+
+func fetchMissing(args []string) {
+	fs := flag.NewFlagSet("fetch-missing", flag.ExitOnError)
+	token := fs.String("t", "", "access token, needed for refreshing attachment URLs")
+	bot := fs.Bool("bot", false, "the token given by -t is a bot token")
+	root := fs.String("o", ".", "archive directory, .tar file or s3://bucket/prefix URL")
+	workers := fs.Int("dl-workers", 8, "number of concurrent file downloads")
+	hostWorkers := fs.Int("dl-host-workers", 4, "number of concurrent file downloads from a single host")
+	dedup := fs.Bool("dedup", false, "store downloaded files once per content")
+	retries := fs.Int("retries", retry.Default.Attempts-1, "number of retries after a transient network or server error")
+	retryDelay := fs.Duration("retry-delay", retry.Default.Base, "initial delay between retries, doubled after each attempt")
+	ignorePolicy := fs.Bool("ignore-policy", false, "download files skipped by the download policy")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: pullcord fetch-missing [options]")
+		fmt.Fprintln(fs.Output(), "Downloads files referenced by existing logs which haven't been downloaded yet.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	st, err := storage.Open(*root)
+	if err != nil {
+		log.Fatal("opening the archive failed: ", err)
+	}
+
+	dl := cdndl.NewManager(st, *workers, *hostWorkers)
+	dl.Retry = retry.Policy{Attempts: *retries + 1, Base: *retryDelay, Max: retry.Default.Max}
+	dl.Dedup = *dedup
+
+	if !*ignorePolicy {
+		policy, err := cdndl.ReadPolicy(st)
+		if err != nil {
+			log.Fatal("error reading the download policy: ", err)
+		}
+		dl.Policy = policy
+	}
+
+	if *token != "" {
+		d, err := newSession(*token, *bot)
+		if err != nil {
+			log.Fatal("login failed:", err)
+		}
+		dl.Refresh = logpull.AttachmentRefresher(d)
+	} else {
+		log.Print("warning: not logged in, attachments with expired URLs can't be downloaded")
+	}
+
+	// downloads made before an error are kept, see do
+	err = logpull.FetchMissing(st, dl)
+	ok := err == nil
+	if err != nil {
+		log.Print(err)
+	}
+
+	if err := dl.Close(); err != nil {
+		log.Print("error finishing downloads: ", err)
+		ok = false
+	}
+
+	if err := st.Close(); err != nil {
+		log.Print("error closing the archive: ", err)
+		ok = false
+	}
+
+	if !ok {
+		os.Exit(1)
+	}
+}
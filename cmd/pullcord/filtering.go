@@ -11,7 +11,7 @@ func wantedChannels(d *discordgo.Session) []discordgo.Channel {
 	channels := make([]discordgo.Channel, 0)
 	gid := ""
 	for {
-		guilds, err := d.UserGuilds(100, "", gid)
+		guilds, err := d.UserGuilds(100, "", gid, false)
 		if err != nil {
 			log.Fatal("error getting guilds:", err)
 		}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/tsudoko/pullcord/archive"
+	"github.com/tsudoko/pullcord/logentry"
+)
+
+func history(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	root := fs.String("o", ".", "archive directory, .tar file or s3://bucket/prefix URL")
+	gid := fs.String("s", "", "server ID to search in (default: all servers)")
+	typ := fs.String("type", "", "only show objects of this record type, e.g. message or member")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: pullcord history [options] <ID>")
+		fmt.Fprintln(fs.Output(), "Shows every change of a message, member, role, channel or other object recorded in the logs.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *typ != "" && logentry.Lookup(*typ) == nil {
+		log.Fatalf("unknown record type %q", *typ)
+	}
+
+	a, err := archive.Open(*root)
+	if err != nil {
+		log.Fatal("opening the archive failed: ", err)
+	}
+	defer a.Close()
+
+	changes, err := a.History(*gid, fs.Arg(0), *typ)
+	if err != nil {
+		log.Fatal("reading the history failed: ", err)
+	}
+	if len(changes) == 0 {
+		log.Fatalf("no records of %s found", fs.Arg(0))
+	}
+
+	for _, c := range changes {
+		fmt.Printf("%s %s %s %s %s (%s)\n", c.Time.Format("2006-01-02 15:04:05 -0700"), c.FetchType, c.Op, c.Type, c.ID, c.Log)
+		for _, f := range c.Fields {
+			if f.Old == "" {
+				fmt.Printf("\t%s: %q\n", f.Field, f.New)
+			} else {
+				fmt.Printf("\t%s: %q -> %q\n", f.Field, f.Old, f.New)
+			}
+		}
+	}
+}
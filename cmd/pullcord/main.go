@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -9,13 +11,16 @@ import (
 
 	"github.com/bwmarrin/discordgo"
 
+	"github.com/tsudoko/pullcord/cdndl"
 	"github.com/tsudoko/pullcord/logpull"
+	"github.com/tsudoko/pullcord/logutil"
+	"github.com/tsudoko/pullcord/retry"
+	"github.com/tsudoko/pullcord/storage"
 )
 
 var (
-	username = flag.String("user", "", "email address")
-	password = flag.String("pass", "", "password")
-	token    = flag.String("t", "", "access token")
+	token = flag.String("t", "", "access token")
+	bot   = flag.Bool("bot", false, "the token given by -t is a bot token")
 
 	cid  = flag.String("c", "", "comma-separated channel IDs to include")
 	gid  = flag.String("s", "", "comma-separated server IDs to include")
@@ -25,74 +30,165 @@ var (
 	cids, gids, xcids, xgids map[string]bool
 
 	historyMode = flag.Bool("history", false, "download the whole history")
+	root        = flag.String("o", ".", "archive directory, .tar file or s3://bucket/prefix URL")
 
-	dlDM = flag.Bool("dm", false, "download DMs")
-	// not fully implemented yet, we currently don't check if all emoji/attachments/etc with log entries have been downloaded
-	//lightMode = flag.Bool("light", false, "skip downloading non-textual data such as attachments or emoji")
+	dlDM      = flag.Bool("dm", false, "download DMs")
+	lightMode = flag.Bool("light", false, "skip downloading non-textual data such as attachments or emoji, see the fetch-missing command")
+	embeds    = flag.Bool("embeds", false, "download images and videos shown in embeds")
+	rotate    = flag.Int64("rotate", 0, "compress channel logs larger than this many MiB into separate segments, 0 to never rotate")
+
+	dlWorkers     = flag.Int("dl-workers", 8, "number of concurrent file downloads")
+	dlHostWorkers = flag.Int("dl-host-workers", 4, "number of concurrent file downloads from a single host")
+	dedup         = flag.Bool("dedup", false, "store downloaded files once per content, see the verify command")
+
+	retries    = flag.Int("retries", retry.Default.Attempts-1, "number of retries after a transient network or server error")
+	retryDelay = flag.Duration("retry-delay", retry.Default.Base, "initial delay between retries, doubled after each attempt")
 )
 
 func do(d *discordgo.Session, _ *discordgo.Ready) {
-	pullers := make(map[string]*logpull.Puller)
 	channels := wantedChannels(d)
+	policy := retry.Policy{Attempts: *retries + 1, Base: *retryDelay, Max: retry.Default.Max}
 
-	if *historyMode {
-		for _, c := range channels {
-			if pullers[c.GuildID] == nil {
-				p, err := logpull.NewPuller(d, c.GuildID)
-				if err != nil {
-					log.Fatalf("[%s] %v", c.GuildID, err)
-				}
-
-				pullers[c.GuildID] = p
-				err = p.PullGuild(c.GuildID)
-				if err != nil {
-					log.Fatalf("[%s] %v", c.GuildID, err)
-				}
-			}
+	st, err := storage.Open(*root)
+	if err != nil {
+		log.Fatal("opening the archive failed: ", err)
+	}
 
-			err := pullers[c.GuildID].PullChannel(&c)
-			if err != nil {
-				log.Fatalf("[%s/%s] %v", c.GuildID, c.ID, err)
-			}
+	dl := cdndl.NewManager(st, *dlWorkers, *dlHostWorkers)
+	dl.Retry = policy
+	dl.Dedup = *dedup
+	dl.Refresh = logpull.AttachmentRefresher(d)
+
+	// tar and S3 archives are only written on Close, so everything is closed
+	// even if pulling fails
+	pullers := make(map[string]*logpull.Puller)
+	err = pull(d, st, dl, pullers, channels, policy)
+	ok := err == nil
+	if err != nil {
+		log.Print(err)
+	}
+
+	for id, p := range pullers {
+		if err := p.Close(); err != nil {
+			log.Printf("[%s] error closing log file: %v", id, err)
+			ok = false
 		}
+	}
 
-		if *dlDM {
-			p, err := logpull.NewPuller(d, "@me")
-			if err != nil {
-				log.Fatalf("[@me] %v", err)
-			}
+	if err := dl.Close(); err != nil {
+		log.Printf("error finishing downloads: %v", err)
+		ok = false
+	}
 
-			pullers["@me"] = p
-			err = p.PullDMGuild()
-			if err != nil {
-				log.Fatalf("[@me] %v", err)
-			}
+	if err := st.Close(); err != nil {
+		log.Printf("error closing the archive: %v", err)
+		ok = false
+	}
+
+	if !ok {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
 
-			uc, err := d.UserChannels()
+// pull pulls the wanted channels, pullers it creates are added to pullers.
+func pull(d *discordgo.Session, st storage.Storage, dl *cdndl.Manager, pullers map[string]*logpull.Puller, channels []discordgo.Channel, policy retry.Policy) error {
+	if err := logutil.UpdateFormatVersion(st); err != nil {
+		return err
+	}
+
+	dlPolicy, err := cdndl.ReadPolicy(st)
+	if err != nil {
+		return fmt.Errorf("error reading the download policy: %v", err)
+	}
+	dl.Policy = dlPolicy
+
+	if !*historyMode {
+		return nil
+	}
+
+	newPuller := func(gid string) (*logpull.Puller, error) {
+		p, err := logpull.NewPuller(d, dl, st, gid)
+		if err != nil {
+			return nil, fmt.Errorf("[%s] %v", gid, err)
+		}
+
+		p.Retry = policy
+		p.LightMode = *lightMode
+		p.Embeds = *embeds
+		p.RotateSize = *rotate << 20
+		pullers[gid] = p
+		return p, nil
+	}
+
+	for _, c := range channels {
+		if pullers[c.GuildID] == nil {
+			p, err := newPuller(c.GuildID)
 			if err != nil {
-				log.Fatalf("[@me] error getting user channels: %v", err)
+				return err
 			}
-
-			for _, c := range uc {
-				c.GuildID = "@me"
-				err := pullers[c.GuildID].PullChannel(c)
-				if err != nil {
-					log.Fatalf("[%s/%s] %v", c.GuildID, c.ID, err)
-				}
+			if err := p.PullGuild(c.GuildID); err != nil {
+				return fmt.Errorf("[%s] %v", c.GuildID, err)
 			}
 		}
 
-		for id, p := range pullers {
-			if err := p.Close(); err != nil {
-				log.Printf("[%s] error closing log file: %v", id, err)
+		if err := pullers[c.GuildID].PullChannel(&c); err != nil {
+			return fmt.Errorf("[%s/%s] %v", c.GuildID, c.ID, err)
+		}
+	}
+
+	if *dlDM {
+		p, err := newPuller("@me")
+		if err != nil {
+			return err
+		}
+		if err := p.PullDMGuild(); err != nil {
+			return fmt.Errorf("[@me] %v", err)
+		}
+
+		for _, dm := range logpull.DMChannels(d) {
+			// the channel is shared with the session state
+			c := *dm
+			c.GuildID = "@me"
+			if err := p.PullChannel(&c); err != nil {
+				return fmt.Errorf("[%s/%s] %v", c.GuildID, c.ID, err)
 			}
 		}
 	}
 
-	os.Exit(0)
+	return nil
+}
+
+// newSession creates a session authenticated with a user token, or a bot
+// token if bot is set.
+func newSession(token string, bot bool) (*discordgo.Session, error) {
+	if token == "" {
+		return nil, errors.New("no token given, see -t")
+	}
+	if bot {
+		token = "Bot " + token
+	}
+	return discordgo.New(token)
+}
+
+// commands which don't need a connection to Discord, run as "pullcord <command>"
+var commands = map[string]func(args []string){
+	"export":        export,
+	"fetch-missing": fetchMissing,
+	"history":       history,
+	"repair":        repair,
+	"state":         state,
+	"verify":        verify,
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+	}
+
 	flag.Parse()
 
 	cids = makeWanted(*cid)
@@ -104,7 +200,7 @@ func main() {
 		log.Fatal("no modes specified, nothing to do")
 	}
 
-	d, err := discordgo.New(*username, *password, *token)
+	d, err := newSession(*token, *bot)
 	if err != nil {
 		log.Fatal("login failed:", err)
 	}
@@ -117,10 +213,6 @@ func main() {
 		log.Fatal("opening the websocket connection failed:", err)
 	}
 
-	if *token == "" {
-		log.Println("token:", d.Token)
-	}
-
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
 	<-sc
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+
+	"github.com/tsudoko/pullcord/logutil"
+	"github.com/tsudoko/pullcord/storage"
+)
+
+func repair(args []string) {
+	fs := flag.NewFlagSet("repair", flag.ExitOnError)
+	root := fs.String("o", ".", "archive directory, .tar file or s3://bucket/prefix URL")
+	dryRun := fs.Bool("n", false, "only list damaged records")
+	strict := fs.Bool("strict", false, "also list records with invalid escape sequences, which are left as they are")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: pullcord repair [options]")
+		fmt.Fprintln(fs.Output(), "Restores deletion records damaged by the TSV decoder of older versions.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	st, err := storage.Open(*root)
+	if err != nil {
+		log.Fatal("opening the archive failed: ", err)
+	}
+
+	n, invalid, err := repairLogs(st, *dryRun, *strict)
+	ok := err == nil
+	if err != nil {
+		log.Print(err)
+	}
+
+	if err := st.Close(); err != nil {
+		log.Print("error closing the archive: ", err)
+		ok = false
+	}
+
+	if !ok {
+		os.Exit(1)
+	}
+
+	if *dryRun {
+		log.Printf("%d damaged records", n)
+	} else {
+		log.Printf("%d records repaired", n)
+	}
+
+	if invalid != 0 {
+		log.Printf("%d records with invalid escape sequences", invalid)
+		os.Exit(1)
+	}
+}
+
+// repairLogs repairs all logs in st, it returns the number of damaged and
+// invalid records.
+func repairLogs(st storage.Storage, dryRun, strict bool) (n, invalid int, err error) {
+	if !dryRun {
+		if err := logutil.UpdateFormatVersion(st); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	logs, err := st.List("channels")
+	if err != nil {
+		return 0, 0, fmt.Errorf("listing logs failed: %v", err)
+	}
+
+	report := func(part string, line int, err error) {
+		fmt.Printf("%s:%d: %v\n", part, line, err)
+		if err == logutil.ErrDamaged {
+			n++
+		} else {
+			invalid++
+		}
+	}
+
+	for _, fpath := range logs {
+		if ok, _ := path.Match("channels/*/*.tsv", fpath); !ok {
+			continue
+		}
+		if err := logutil.Repair(st, fpath, dryRun, strict, report); err != nil {
+			return n, invalid, fmt.Errorf("%s: %v", fpath, err)
+		}
+	}
+
+	return n, invalid, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tsudoko/pullcord/archive"
+	"github.com/tsudoko/pullcord/logentry"
+)
+
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseTime parses a timestamp given on the command line, in local time
+// unless the timezone is given.
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected e.g. 2006-01-02 or 2006-01-02T15:04:05", s)
+}
+
+func roleNames(s *archive.State, ids []string) string {
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = id
+		if r := s.Roles[id]; r != nil {
+			names[i] = r.Name
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func printState(s *archive.State) {
+	if s.Guild != nil {
+		fmt.Printf("guild %s (%s)\n", s.Guild.Name, s.Guild.ID)
+	}
+
+	var chans []*logentry.ChannelRecord
+	for _, c := range s.Channels {
+		chans = append(chans, c)
+	}
+	sort.Slice(chans, func(i, j int) bool {
+		if chans[i].Position != chans[j].Position {
+			return chans[i].Position < chans[j].Position
+		}
+		return chans[i].ID < chans[j].ID
+	})
+	fmt.Printf("\n%d channels\n", len(chans))
+	for _, c := range chans {
+		fmt.Printf("\t%s\t#%s\t%s", c.ID, c.Name, c.ChannelType)
+		if cat := s.Channels[c.CategoryID]; cat != nil {
+			fmt.Printf("\tin %s", cat.Name)
+		}
+		if c.Topic != "" {
+			fmt.Printf("\t%q", c.Topic)
+		}
+		fmt.Println()
+	}
+
+	var roles []*logentry.RoleRecord
+	for _, r := range s.Roles {
+		roles = append(roles, r)
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Position > roles[j].Position })
+	holders := make(map[string][]string)
+	for _, m := range s.Members {
+		for _, id := range m.Roles {
+			holders[id] = append(holders[id], m.Username)
+		}
+	}
+	fmt.Printf("\n%d roles\n", len(roles))
+	for _, r := range roles {
+		sort.Strings(holders[r.ID])
+		fmt.Printf("\t%s\t%s\t#%06x\t%d members", r.ID, r.Name, r.Color, len(holders[r.ID]))
+		if len(holders[r.ID]) > 0 {
+			fmt.Printf(": %s", strings.Join(holders[r.ID], ", "))
+		}
+		fmt.Println()
+	}
+
+	var members []*logentry.MemberRecord
+	for _, m := range s.Members {
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Username < members[j].Username })
+	fmt.Printf("\n%d members\n", len(members))
+	for _, m := range members {
+		fmt.Printf("\t%s\t%s", m.UserID, m.Username)
+		if m.Nick != "" {
+			fmt.Printf("\tnick %s", m.Nick)
+		}
+		if len(m.Roles) > 0 {
+			fmt.Printf("\troles %s", roleNames(s, m.Roles))
+		}
+		fmt.Println()
+	}
+
+	var emoji []*logentry.EmojiRecord
+	for _, e := range s.Emoji {
+		emoji = append(emoji, e)
+	}
+	sort.Slice(emoji, func(i, j int) bool { return emoji[i].Name < emoji[j].Name })
+	fmt.Printf("\n%d emoji\n", len(emoji))
+	for _, e := range emoji {
+		fmt.Printf("\t%s\t:%s:\n", e.ID, e.Name)
+	}
+}
+
+func state(args []string) {
+	fs := flag.NewFlagSet("state", flag.ExitOnError)
+	root := fs.String("o", ".", "archive directory, .tar file or s3://bucket/prefix URL")
+	gid := fs.String("s", "", "server ID")
+	at := fs.String("at", "", "time to show the state at, e.g. 2006-01-02 or 2006-01-02T15:04:05 (default: now)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: pullcord state -s <server ID> [-at <time>] [options]")
+		fmt.Fprintln(fs.Output(), "Shows the channels, roles, members and emoji of a server as they were at a given time.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *gid == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var t time.Time
+	if *at != "" {
+		var err error
+		if t, err = parseTime(*at); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	a, err := archive.Open(*root)
+	if err != nil {
+		log.Fatal("opening the archive failed: ", err)
+	}
+	defer a.Close()
+
+	s, err := a.GuildState(*gid, t)
+	if err != nil {
+		log.Fatalf("[%s] reconstructing server state failed: %v", *gid, err)
+	}
+
+	printState(s)
+}
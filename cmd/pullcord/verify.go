@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/tsudoko/pullcord/cdndl"
+	"github.com/tsudoko/pullcord/storage"
+)
+
+func verify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	root := fs.String("o", ".", "archive directory, .tar file or s3://bucket/prefix URL")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: pullcord verify")
+		fmt.Fprintln(fs.Output(), "Checks downloaded files against their hashes in the manifest and the deduplicated media store.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	st, err := storage.Open(*root)
+	if err != nil {
+		log.Fatal("opening the archive failed: ", err)
+	}
+	defer st.Close()
+
+	nbad := 0
+	bad := func(fPath string, err error) {
+		fmt.Printf("%s: %v\n", fPath, err)
+		nbad++
+	}
+
+	if err := cdndl.VerifyManifest(st, bad); err != nil {
+		log.Fatal("verifying files failed: ", err)
+	}
+
+	if err := cdndl.VerifyBlobs(st, bad); err != nil {
+		log.Fatal("verifying files failed: ", err)
+	}
+
+	if nbad != 0 {
+		log.Printf("%d bad files", nbad)
+		os.Exit(1)
+	}
+}
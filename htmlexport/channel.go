@@ -0,0 +1,508 @@
+package htmlexport
+
+import (
+	"fmt"
+	"html/template"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/tsudoko/pullcord/archive"
+	"github.com/tsudoko/pullcord/cdndl"
+	"github.com/tsudoko/pullcord/logentry"
+	"github.com/tsudoko/pullcord/render"
+)
+
+const timeLayout = "2006-01-02 15:04"
+
+type attachmentView struct {
+	Name  string
+	Href  string
+	Size  string
+	Image bool
+	Video bool
+}
+
+type embedFieldView struct {
+	Name   string
+	Value  template.HTML
+	Inline bool
+}
+
+type embedView struct {
+	Color       string
+	Author      string
+	AuthorIcon  string
+	Title       string
+	URL         string
+	Description template.HTML
+	Fields      []embedFieldView
+	Image       string
+	Thumbnail   string
+	Video       string
+	Footer      string
+	FooterIcon  string
+}
+
+type reactionView struct {
+	Emoji string
+	Image string
+	Count int
+}
+
+type pollAnswerView struct {
+	Text  string
+	Emoji string
+	Image string
+	Votes int
+}
+
+type pollView struct {
+	Question string
+	Answers  []pollAnswerView
+	Final    bool
+}
+
+type replyView struct {
+	Href    string
+	Author  string
+	Excerpt string
+}
+
+type forwardView struct {
+	Time        string
+	Content     template.HTML
+	Attachments []attachmentView
+	Embeds      []embedView
+}
+
+type messageView struct {
+	ID          string
+	Author      string
+	AuthorID    string
+	Avatar      string
+	Bot         bool // sent by a webhook
+	Time        string
+	Edited      string
+	Deleted     bool
+	System      string // description of non-default messages
+	Content     template.HTML
+	Reply       *replyView
+	Forward     *forwardView
+	Attachments []attachmentView
+	Embeds      []embedView
+	Reactions   []reactionView
+	Poll        *pollView
+}
+
+type pageLink struct {
+	N       int
+	Href    string
+	Current bool
+}
+
+// messageTime returns the time a message was sent, from its ID.
+func messageTime(id string) string {
+	t, err := discordgo.SnowflakeTimestamp(id)
+	if err != nil {
+		return ""
+	}
+	return t.Local().Format(timeLayout)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(timeLayout)
+}
+
+func excerpt(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > 100 {
+		return string(r[:100]) + "…"
+	}
+	return s
+}
+
+var systemMessages = map[string]string{
+	"recipient_add":          "added someone to the group",
+	"recipient_remove":       "removed someone from the group",
+	"call":                   "started a call",
+	"channel_name_change":    "changed the channel name",
+	"channel_icon_change":    "changed the channel icon",
+	"channel_pinned_message": "pinned a message",
+	"guild_member_join":      "joined the server",
+}
+
+func isImage(name, contentType string) bool {
+	if contentType != "" {
+		return strings.HasPrefix(contentType, "image/")
+	}
+	switch strings.ToLower(path.Ext(name)) {
+	case ".png", ".jpg", ".jpeg", ".gif", ".webp":
+		return true
+	}
+	return false
+}
+
+func isVideo(name, contentType string) bool {
+	if contentType != "" {
+		return strings.HasPrefix(contentType, "video/")
+	}
+	switch strings.ToLower(path.Ext(name)) {
+	case ".mp4", ".webm", ".mov":
+		return true
+	}
+	return false
+}
+
+// replyRef is what's shown of a replied to message.
+type replyRef struct {
+	authorID string
+	excerpt  string
+}
+
+// channelPage renders messages of a single channel.
+type channelPage struct {
+	*page
+	gid    string
+	cid    string
+	state  *archive.State
+	media  map[string]string    // embed media URL -> local path
+	refs   map[string]*replyRef // replied to messages, nil if not archived
+	pageOf map[string]int
+	opts   *render.HTMLOptions
+}
+
+// content renders markdown in the content of a message, embed descriptions
+// and field values.
+func (p *channelPage) content(s string) template.HTML {
+	return render.HTML(render.Parse(s), p.opts)
+}
+
+// channelLink returns the URL of the first page of a channel of the guild.
+func (p *channelPage) channelLink(cid string) string {
+	if p.state.Channels[cid] == nil {
+		return ""
+	}
+	return p.exportLink(path.Join(p.gid, cid, "1.html"))
+}
+
+func (p *channelPage) attachments(cid string, recs []*logentry.AttachmentRecord, snapshot bool) []attachmentView {
+	var views []attachmentView
+	for _, a := range recs {
+		if a.Snapshot != snapshot {
+			continue
+		}
+		views = append(views, attachmentView{
+			Name:  a.Filename,
+			Href:  p.file(cdndl.AttachmentURL(cid, a.ID, a.Filename)),
+			Size:  formatSize(a.Size),
+			Image: isImage(a.Filename, a.ContentType),
+			Video: isVideo(a.Filename, a.ContentType),
+		})
+	}
+	return views
+}
+
+// embedFile returns the URL of a file referenced by an embed, preferring the
+// copy recorded in embedmedia records.
+func (p *channelPage) embedFile(URL, proxyURL string) string {
+	for _, u := range []string{proxyURL, URL} {
+		if fPath := p.media[u]; u != "" && fPath != "" {
+			if stored, err := cdndl.ResolvePath(p.e.st, p.e.index, fPath); err == nil {
+				return p.link(stored)
+			}
+		}
+	}
+	if proxyURL != "" {
+		return p.file(proxyURL)
+	}
+	return p.file(URL)
+}
+
+func (p *channelPage) embeds(recs []*logentry.EmbedRecord, snapshot bool) []embedView {
+	var views []embedView
+	for _, r := range recs {
+		if r.Snapshot != snapshot {
+			continue
+		}
+
+		e := &r.Embed
+		v := embedView{
+			Title:       e.Title,
+			URL:         e.URL,
+			Description: p.content(e.Description),
+		}
+		if e.Color != 0 {
+			v.Color = fmt.Sprintf("#%06x", e.Color)
+		}
+		if e.Author != nil {
+			v.Author = e.Author.Name
+			v.AuthorIcon = p.embedFile(e.Author.IconURL, e.Author.ProxyIconURL)
+		}
+		for _, f := range e.Fields {
+			v.Fields = append(v.Fields, embedFieldView{f.Name, p.content(f.Value), f.Inline})
+		}
+		if e.Image != nil {
+			v.Image = p.embedFile(e.Image.URL, e.Image.ProxyURL)
+		}
+		if e.Thumbnail != nil {
+			v.Thumbnail = p.embedFile(e.Thumbnail.URL, e.Thumbnail.ProxyURL)
+		}
+		if e.Video != nil {
+			v.Video = p.embedFile(e.Video.URL, "")
+		}
+		if e.Footer != nil {
+			v.Footer = e.Footer.Text
+			v.FooterIcon = p.embedFile(e.Footer.IconURL, e.Footer.ProxyIconURL)
+		}
+		views = append(views, v)
+	}
+	return views
+}
+
+func (p *channelPage) reactions(recs []*logentry.ReactionRecord) []reactionView {
+	var views []reactionView
+	index := make(map[string]int)
+	for _, r := range recs {
+		i, ok := index[r.Emoji]
+		if !ok {
+			i = len(views)
+			index[r.Emoji] = i
+			name := r.Emoji
+			if j := strings.LastIndex(name, ":"); j != -1 {
+				name = ":" + name[:j] + ":"
+			}
+			views = append(views, reactionView{Emoji: name, Image: p.emoji(r.Emoji)})
+		}
+		views[i].Count += r.Count
+	}
+	return views
+}
+
+func (p *channelPage) poll(m *archive.Message) *pollView {
+	if m.Poll == nil {
+		return nil
+	}
+
+	v := &pollView{Question: m.Poll.Question}
+	votes := make(map[int]int)
+	for _, r := range m.PollResults {
+		votes[r.ID] = r.Count
+		v.Final = v.Final || r.Finalized
+	}
+	for _, a := range m.PollAnswers {
+		name := a.Emoji
+		if strings.Contains(name, ":") {
+			name = ""
+		}
+		v.Answers = append(v.Answers, pollAnswerView{a.Text, name, p.emoji(a.Emoji), votes[a.ID]})
+	}
+	return v
+}
+
+// messageLink returns the URL of a message of the channel.
+func (p *channelPage) messageLink(id string) string {
+	n, ok := p.pageOf[id]
+	if !ok {
+		return ""
+	}
+	return p.exportLink(path.Join(p.gid, p.cid, strconv.Itoa(n)+".html")) + "#m" + id
+}
+
+func (p *channelPage) message(m *archive.Message) messageView {
+	v := messageView{
+		ID:          m.ID,
+		AuthorID:    m.AuthorID,
+		Author:      userName(p.state, m.AuthorID),
+		Avatar:      p.avatar(p.gid, p.state.Users[m.AuthorID]),
+		Time:        messageTime(m.ID),
+		Edited:      formatTime(m.EditedTime),
+		Deleted:     m.Deleted,
+		System:      systemMessages[m.MessageType],
+		Content:     p.content(m.Content),
+		Attachments: p.attachments(p.cid, m.Attachments, false),
+		Embeds:      p.embeds(m.Embeds, false),
+		Reactions:   p.reactions(m.Reactions),
+		Poll:        p.poll(m),
+	}
+
+	if m.Webhook {
+		v.Bot = true
+		if m.UsernameOverride != "" {
+			v.Author = m.UsernameOverride
+		}
+		if m.AvatarOverride != "" {
+			v.Avatar = p.file(animated(m.AvatarOverride,
+				discordgo.EndpointUserAvatar(m.AuthorID, m.AvatarOverride),
+				discordgo.EndpointUserAvatarAnimated(m.AuthorID, m.AvatarOverride)))
+		}
+	}
+
+	if m.Snapshot != nil {
+		v.Forward = &forwardView{
+			Time:        formatTime(m.Snapshot.Timestamp),
+			Content:     p.content(m.Snapshot.Content),
+			Attachments: p.attachments(m.RefChannelID, m.Attachments, true),
+			Embeds:      p.embeds(m.Embeds, true),
+		}
+	} else if m.RefMessageID != "" {
+		r := &replyView{Href: p.messageLink(m.RefMessageID), Excerpt: "original message wasn't archived"}
+		if ref := p.refs[m.RefMessageID]; ref != nil {
+			r.Author = userName(p.state, ref.authorID)
+			r.Excerpt = ref.excerpt
+		}
+		v.Reply = r
+	}
+
+	return v
+}
+
+// exportChannel renders a channel log into pages, it returns the number of
+// messages. Messages are streamed from the log three times: to find the page
+// of every message, to collect excerpts of replied to messages, and to render
+// the pages. Only messages of pages which aren't complete yet are kept in
+// memory.
+func (e *Exporter) exportChannel(g *archive.Guild, c *archive.Channel, s *archive.State) (int, error) {
+	cp := &channelPage{
+		gid:    c.GuildID,
+		cid:    c.ID,
+		state:  s,
+		media:  make(map[string]string),
+		refs:   make(map[string]*replyRef),
+		pageOf: make(map[string]int),
+	}
+	cp.opts = &render.HTMLOptions{
+		Resolver:   render.StateResolver{State: s},
+		EmojiURL:   func(id string, _ bool) string { return cp.emoji(":" + id) },
+		ChannelURL: cp.channelLink,
+	}
+
+	var ids []string
+	err := e.a.Messages(c.GuildID, c.ID, func(m *archive.Message) error {
+		ids = append(ids, m.ID)
+		if m.Snapshot == nil && m.RefMessageID != "" {
+			cp.refs[m.RefMessageID] = nil
+		}
+		for _, em := range m.EmbedMedia {
+			cp.media[em.URL] = em.Path
+			cp.media[em.ProxyURL] = em.Path
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	sort.Slice(ids, func(i, j int) bool { return archive.LessID(ids[i], ids[j]) })
+
+	perPage := e.PerPage
+	if perPage <= 0 {
+		perPage = len(ids) + 1
+	}
+	npages := (len(ids) + perPage - 1) / perPage
+	if npages == 0 {
+		npages = 1
+	}
+	for i, id := range ids {
+		cp.pageOf[id] = i/perPage + 1
+	}
+
+	if len(cp.refs) > 0 {
+		err := e.a.Messages(c.GuildID, c.ID, func(m *archive.Message) error {
+			if _, ok := cp.refs[m.ID]; ok {
+				cp.refs[m.ID] = &replyRef{
+					authorID: m.AuthorID,
+					excerpt:  excerpt(render.PlainText(render.Parse(m.Content), cp.opts.Resolver)),
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	writePage := func(n int, msgs []*archive.Message) error {
+		sort.Slice(msgs, func(i, j int) bool { return archive.LessID(msgs[i].ID, msgs[j].ID) })
+		cp.page = &page{e, path.Join(c.GuildID, c.ID, strconv.Itoa(n)+".html")}
+
+		var pages []pageLink
+		for i := 1; i <= npages; i++ {
+			pages = append(pages, pageLink{i, cp.exportLink(path.Join(c.GuildID, c.ID, strconv.Itoa(i)+".html")), i == n})
+		}
+
+		var views []messageView
+		for _, m := range msgs {
+			views = append(views, cp.message(m))
+		}
+
+		return cp.write(channelTemplate, map[string]interface{}{
+			"Title":    channelName(c, s) + " - " + guildName(g),
+			"Channel":  channelName(c, s),
+			"Topic":    topic(c),
+			"Style":    cp.exportLink("style.css"),
+			"Guild":    guildName(g),
+			"GuildURL": cp.exportLink(path.Join(c.GuildID, "index.html")),
+			"Pages":    pages,
+			"Messages": views,
+		})
+	}
+
+	// messages come in the order of their last records, so a page is
+	// written once all of its messages have been seen
+	pending := make(map[int][]*archive.Message)
+	err = e.a.Messages(c.GuildID, c.ID, func(m *archive.Message) error {
+		n, ok := cp.pageOf[m.ID]
+		if !ok {
+			// logged after the first pass
+			return nil
+		}
+		pending[n] = append(pending[n], m)
+		if len(pending[n]) < pageSize(n, perPage, len(ids)) {
+			return nil
+		}
+		msgs := pending[n]
+		delete(pending, n)
+		return writePage(n, msgs)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// pages of messages which were changed between passes, and the empty
+	// page of a channel without messages
+	for n := 1; n <= npages; n++ {
+		if msgs, ok := pending[n]; ok || len(ids) == 0 {
+			if err := writePage(n, msgs); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return len(ids), nil
+}
+
+// pageSize returns the number of messages on the n-th page.
+func pageSize(n, perPage, total int) int {
+	return min(n*perPage, total) - (n-1)*perPage
+}
+
+func topic(c *archive.Channel) string {
+	if c.Record == nil {
+		return ""
+	}
+	return c.Record.Topic
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,82 @@
+package htmlexport
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/tsudoko/pullcord/archive"
+	"github.com/tsudoko/pullcord/storage"
+	"github.com/tsudoko/pullcord/tsv"
+)
+
+var messageIDRegexp = regexp.MustCompile(`id="m([0-9]+)"`)
+
+func TestExportChannelPages(t *testing.T) {
+	st := storage.Dir(t.TempDir())
+	w, err := st.Append(archive.ChannelLog("1", "2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const ts = "2020-01-01T00:00:00.000000+00:00"
+	message := func(id, content, ref string) []string {
+		return []string{ts, "history", "add", "message", id, "9", "", "", content, "", "", "", "", "1", "2", ref}
+	}
+	// pulls write newer messages first, and messages change later
+	for _, e := range [][]string{
+		message("13", "reply", "11"),
+		message("12", "c", ""),
+		message("11", "original **message**", ""),
+		message("15", "e", ""),
+		message("14", "d", ""),
+		message("10", "a", ""),
+		{ts, "realtime", "add", "reaction", "9", "12", "👍", "1"},
+	} {
+		tsv.Write(w, e)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	e := New(archive.New(st))
+	e.PerPage = 2
+	if err := e.ExportGuild("1"); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(name string) string {
+		t.Helper()
+		f, err := st.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		b, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	pages := map[string]string{
+		"1.html": "10 11",
+		"2.html": "12 13",
+		"3.html": "14 15",
+	}
+	for name, want := range pages {
+		page := read(DefaultDir + "/1/2/" + name)
+		var ids []string
+		for _, m := range messageIDRegexp.FindAllStringSubmatch(page, -1) {
+			ids = append(ids, m[1])
+		}
+		if got := strings.Join(ids, " "); got != want {
+			t.Errorf("%s: got messages %s, want %s", name, got, want)
+		}
+		if name == "2.html" {
+			if !strings.Contains(page, "original message") || !strings.Contains(page, `/1.html#m11"`) {
+				t.Errorf("%s: reply without the excerpt or link of the original message", name)
+			}
+		}
+	}
+}
@@ -0,0 +1,342 @@
+// Package htmlexport renders archives into static HTML pages which can be
+// browsed offline. Pages are stored in the archive itself, so that they can
+// link to the downloaded files with relative URLs.
+package htmlexport
+
+import (
+	"bytes"
+	"html/template"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/tsudoko/pullcord/archive"
+	"github.com/tsudoko/pullcord/cdndl"
+	"github.com/tsudoko/pullcord/logentry"
+	"github.com/tsudoko/pullcord/storage"
+)
+
+// DefaultDir is the directory pages are stored in by default, relative to
+// the archive root.
+const DefaultDir = "export/html"
+
+type Exporter struct {
+	PerPage int    // number of messages per page
+	Dir     string // directory pages are stored in, relative to the archive root
+
+	a        *archive.Archive
+	st       storage.Storage
+	resolved map[string]string // URL -> local path, empty if not downloaded
+	index    map[string]string // blob index, read by ExportGuild
+}
+
+func New(a *archive.Archive) *Exporter {
+	return &Exporter{
+		PerPage:  500,
+		Dir:      DefaultDir,
+		a:        a,
+		st:       a.Storage(),
+		resolved: make(map[string]string),
+	}
+}
+
+// page is written to a file in e.Dir, links are relative to it.
+type page struct {
+	e    *Exporter
+	name string // path relative to e.Dir
+}
+
+// root returns the relative URL of the archive root.
+func (p *page) root() string {
+	depth := strings.Count(path.Join(p.e.Dir, p.name), "/")
+	return strings.Repeat("../", depth)
+}
+
+func escapePath(fPath string) string {
+	parts := strings.Split(fPath, "/")
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	return strings.Join(parts, "/")
+}
+
+// link returns the relative URL of a file in the archive.
+func (p *page) link(fPath string) string {
+	return p.root() + escapePath(fPath)
+}
+
+// exportLink returns the relative URL of another page.
+func (p *page) exportLink(name string) string {
+	return strings.Repeat("../", strings.Count(p.name, "/")) + escapePath(name)
+}
+
+// file returns the relative URL of the local copy of a file, or URL itself
+// if it hasn't been downloaded. Deduplicated files are linked in the blob
+// store unless they were linked back to their paths.
+func (p *page) file(URL string) string {
+	if URL == "" {
+		return ""
+	}
+
+	fPath, ok := p.e.resolved[URL]
+	if !ok {
+		fPath, _ = cdndl.Resolve(p.e.st, p.e.index, URL)
+		p.e.resolved[URL] = fPath
+	}
+	if fPath == "" {
+		return URL
+	}
+	return p.link(fPath)
+}
+
+func (p *page) write(t *template.Template, data interface{}) error {
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return err
+	}
+	return p.e.st.Put(path.Join(p.e.Dir, p.name), bytes.NewReader(b.Bytes()))
+}
+
+func animated(hash, png, gif string) string {
+	if strings.HasPrefix(hash, "a_") {
+		return gif
+	}
+	return png
+}
+
+// avatar returns the URL of the avatar of a user, preferring the server
+// avatar.
+func (p *page) avatar(gid string, m *logentry.MemberRecord) string {
+	switch {
+	case m == nil:
+		return ""
+	case m.GuildAvatar != "" && gid != archive.DMGuildID:
+		return p.file(animated(m.GuildAvatar,
+			discordgo.EndpointGuildMemberAvatar(gid, m.UserID, m.GuildAvatar),
+			discordgo.EndpointGuildMemberAvatarAnimated(gid, m.UserID, m.GuildAvatar)))
+	case m.Avatar != "":
+		return p.file(animated(m.Avatar,
+			discordgo.EndpointUserAvatar(m.UserID, m.Avatar),
+			discordgo.EndpointUserAvatarAnimated(m.UserID, m.Avatar)))
+	}
+	return ""
+}
+
+// emoji returns the URL of a custom emoji, e is "name:id" as in reactions,
+// or an empty string for Unicode emoji.
+func (p *page) emoji(e string) string {
+	i := strings.LastIndex(e, ":")
+	if i == -1 {
+		return ""
+	}
+
+	id := e[i+1:]
+	for _, ext := range []string{"png", "gif"} {
+		URL := cdndl.EndpointCDNEmojis + id + "." + ext
+		if f := p.file(URL); f != URL {
+			return f
+		}
+	}
+	return cdndl.EndpointCDNEmojis + id + ".png"
+}
+
+type guildLink struct {
+	Name string
+	Href string
+}
+
+// Export renders all guilds in the archive and an index page listing them.
+func (e *Exporter) Export() error {
+	guilds, err := e.a.Guilds()
+	if err != nil {
+		return err
+	}
+
+	var gids []string
+	for _, g := range guilds {
+		gids = append(gids, g.ID)
+	}
+	return e.ExportGuilds(gids)
+}
+
+// ExportGuilds renders the given guilds and an index page listing them.
+func (e *Exporter) ExportGuilds(gids []string) error {
+	p := &page{e, "index.html"}
+	var links []guildLink
+	for _, gid := range gids {
+		g, err := e.a.Guild(gid)
+		if err != nil {
+			return err
+		}
+		if err := e.ExportGuild(gid); err != nil {
+			return err
+		}
+		links = append(links, guildLink{guildName(g), p.exportLink(path.Join(gid, "index.html"))})
+	}
+
+	if err := e.st.Put(path.Join(e.Dir, "style.css"), strings.NewReader(style)); err != nil {
+		return err
+	}
+	return p.write(indexTemplate, map[string]interface{}{
+		"Title":  "Archive",
+		"Style":  p.exportLink("style.css"),
+		"Guilds": links,
+	})
+}
+
+func guildName(g *archive.Guild) string {
+	switch {
+	case g.ID == archive.DMGuildID:
+		return "Direct messages"
+	case g.Name != "":
+		return g.Name
+	}
+	return g.ID
+}
+
+// channelName returns the name of a channel, or the names of the recipients
+// of a DM channel.
+func channelName(c *archive.Channel, s *archive.State) string {
+	if c.Name != "" {
+		return "#" + c.Name
+	}
+	if c.Record != nil && len(c.Record.RecipientIDs) > 0 {
+		var names []string
+		for _, id := range c.Record.RecipientIDs {
+			names = append(names, userName(s, id))
+		}
+		return strings.Join(names, ", ")
+	}
+	return c.ID
+}
+
+// userName returns the name of a user as shown in a guild.
+func userName(s *archive.State, uid string) string {
+	if m := s.Members[uid]; m != nil && m.Nick != "" {
+		return m.Nick
+	}
+	if u := s.Users[uid]; u != nil {
+		return u.Username
+	}
+	return uid
+}
+
+type channelLink struct {
+	Name     string
+	Href     string
+	Messages int
+	Deleted  bool
+}
+
+type category struct {
+	Name     string
+	Channels []channelLink
+}
+
+// ExportGuild renders all channels of a guild and an index page listing
+// them.
+func (e *Exporter) ExportGuild(gid string) error {
+	if e.index == nil {
+		index, err := cdndl.ReadIndex(e.st)
+		if err != nil {
+			return err
+		}
+		e.index = index
+	}
+
+	g, err := e.a.Guild(gid)
+	if err != nil {
+		return err
+	}
+
+	s, err := e.a.GuildState(gid, time.Time{})
+	if os.IsNotExist(err) {
+		s = new(archive.State)
+	} else if err != nil {
+		return err
+	}
+
+	chans, err := e.a.Channels(gid)
+	if err != nil {
+		return err
+	}
+
+	pos := func(id string) int {
+		if c := s.Channels[id]; c != nil {
+			return c.Position
+		}
+		return 0
+	}
+	sort.SliceStable(chans, func(i, j int) bool {
+		ci, cj := chans[i], chans[j]
+		var pi, pj [2]int
+		if ci.Record != nil {
+			pi = [2]int{pos(ci.Record.CategoryID), ci.Record.Position}
+		}
+		if cj.Record != nil {
+			pj = [2]int{pos(cj.Record.CategoryID), cj.Record.Position}
+		}
+		if pi[0] != pj[0] {
+			return pi[0] < pj[0]
+		}
+		return pi[1] < pj[1]
+	})
+
+	p := &page{e, path.Join(gid, "index.html")}
+	var cats []*category
+	byName := make(map[string]*category)
+	for _, c := range chans {
+		n, err := e.exportChannel(g, c, s)
+		if err != nil {
+			return err
+		}
+
+		catName := ""
+		if c.Record != nil {
+			if cat := s.Channels[c.Record.CategoryID]; cat != nil {
+				catName = cat.Name
+			}
+		}
+		if byName[catName] == nil {
+			byName[catName] = &category{Name: catName}
+			cats = append(cats, byName[catName])
+		}
+		byName[catName].Channels = append(byName[catName].Channels, channelLink{
+			Name:     channelName(c, s),
+			Href:     p.exportLink(path.Join(gid, c.ID, "1.html")),
+			Messages: n,
+			Deleted:  c.Deleted,
+		})
+	}
+
+	var icon string
+	if g.Record != nil && g.Record.Icon != "" {
+		icon = p.file(animated(g.Record.Icon,
+			discordgo.EndpointGuildIcon(gid, g.Record.Icon),
+			discordgo.EndpointGuildIconAnimated(gid, g.Record.Icon)))
+	}
+
+	return p.write(guildTemplate, map[string]interface{}{
+		"Title":      guildName(g),
+		"Style":      p.exportLink("style.css"),
+		"Index":      p.exportLink("index.html"),
+		"Icon":       icon,
+		"Categories": cats,
+	})
+}
+
+func formatSize(n int) string {
+	switch {
+	case n >= 1<<20:
+		return strconv.FormatFloat(float64(n)/(1<<20), 'f', 1, 64) + " MiB"
+	case n >= 1<<10:
+		return strconv.FormatFloat(float64(n)/(1<<10), 'f', 1, 64) + " KiB"
+	}
+	return strconv.Itoa(n) + " B"
+}
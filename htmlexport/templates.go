@@ -0,0 +1,129 @@
+package htmlexport
+
+import "html/template"
+
+const style = `body { margin: 0; background: #313338; color: #dbdee1; font: 15px/1.375 sans-serif; }
+a { color: #00a8fc; text-decoration: none; }
+a:hover { text-decoration: underline; }
+header { position: sticky; top: 0; padding: 8px 16px; background: #2b2d31; border-bottom: 1px solid #1f2023; }
+header h1 { display: inline; margin: 0 8px 0 0; font-size: 17px; color: #f2f3f5; }
+header .topic { color: #949ba4; }
+main { padding: 8px 16px 32px; }
+nav.pages { padding: 8px 16px; }
+nav.pages a, nav.pages span { margin-right: 6px; }
+nav.pages span { font-weight: bold; }
+.list li { margin: 4px 0; }
+.list .deleted { color: #949ba4; }
+.category { margin-top: 16px; color: #949ba4; text-transform: uppercase; font-size: 12px; font-weight: bold; }
+.message { display: flex; padding: 4px 0; }
+.message:target { background: #3f4147; }
+.message .avatar { flex: none; width: 40px; height: 40px; margin-right: 16px; border-radius: 50%; background: #5865f2; object-fit: cover; }
+.message .body { min-width: 0; flex: 1; }
+.author { color: #f2f3f5; font-weight: 500; }
+.bot { margin-left: 4px; padding: 0 4px; border-radius: 3px; background: #5865f2; color: #fff; font-size: 10px; vertical-align: middle; }
+.time, .edited, .system { color: #949ba4; font-size: 12px; margin-left: 4px; }
+.deleted-label { color: #f23f43; font-size: 12px; margin-left: 4px; }
+.content { white-space: normal; overflow-wrap: anywhere; }
+.reply { color: #949ba4; font-size: 13px; }
+.forward { margin: 4px 0; padding-left: 8px; border-left: 4px solid #4e5058; }
+.forward .label { color: #949ba4; font-size: 12px; font-style: italic; }
+.attachment { margin: 4px 0; }
+.attachment img, .attachment video { max-width: 400px; max-height: 300px; border-radius: 4px; }
+.embed { max-width: 520px; margin: 4px 0; padding: 8px 12px; border-left: 4px solid #1e1f22; border-radius: 4px; background: #2b2d31; }
+.embed .title { font-weight: bold; }
+.embed .field { margin-top: 4px; }
+.embed .field-name { font-weight: bold; font-size: 13px; }
+.embed img.image, .embed video { max-width: 100%; max-height: 300px; margin-top: 8px; border-radius: 4px; }
+.embed img.thumbnail { float: right; max-width: 80px; max-height: 80px; margin-left: 8px; border-radius: 4px; }
+.embed img.icon { width: 20px; height: 20px; border-radius: 50%; vertical-align: middle; margin-right: 4px; }
+.embed .footer { margin-top: 8px; color: #949ba4; font-size: 12px; }
+.reactions { margin-top: 4px; }
+.reaction { display: inline-block; margin-right: 4px; padding: 0 6px; border-radius: 8px; background: #2b2d31; font-size: 14px; }
+.reaction img, .poll img, img.emoji { width: 18px; height: 18px; vertical-align: middle; }
+.poll { max-width: 440px; margin: 4px 0; padding: 8px 12px; border-radius: 8px; background: #2b2d31; }
+.poll .question { font-weight: bold; }
+.poll .answer { display: flex; justify-content: space-between; margin-top: 4px; }
+.content code, .embed code { padding: 0 2px; border-radius: 3px; background: #2b2d31; font-size: 85%; }
+.content pre, .embed pre { margin: 4px 0; padding: 8px; border: 1px solid #1e1f22; border-radius: 4px; background: #2b2d31; white-space: pre-wrap; }
+.content pre code, .embed pre code { padding: 0; background: none; }
+.content blockquote, .embed blockquote { margin: 0; padding-left: 12px; border-left: 4px solid #4e5058; }
+.content h1, .content h2, .content h3 { margin: 8px 0 4px; color: #f2f3f5; }
+.content h1 { font-size: 24px; }
+.content h2 { font-size: 20px; }
+.content h3 { font-size: 16px; }
+.spoiler { border-radius: 3px; background: #1e1f22; color: transparent; }
+.spoiler:hover { background: #2b2d31; color: inherit; }
+.mention { padding: 0 2px; border-radius: 3px; background: rgba(88, 101, 242, .3); color: #c9cdfb; }
+`
+
+const head = `{{define "head"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<link rel="stylesheet" href="{{.Style}}">
+</head>
+<body>
+{{end}}`
+
+var indexTemplate = template.Must(template.New("index").Parse(head + `{{template "head" .}}<header><h1>{{.Title}}</h1></header>
+<main>
+<ul class="list">
+{{range .Guilds}}<li><a href="{{.Href}}">{{.Name}}</a></li>
+{{end}}</ul>
+</main>
+</body>
+</html>
+`))
+
+var guildTemplate = template.Must(template.New("guild").Parse(head + `{{template "head" .}}<header>{{if .Icon}}<img class="emoji" src="{{.Icon}}" alt=""> {{end}}<h1>{{.Title}}</h1> <a href="{{.Index}}">all servers</a></header>
+<main>
+{{range .Categories}}{{if .Name}}<div class="category">{{.Name}}</div>{{end}}
+<ul class="list">
+{{range .Channels}}<li{{if .Deleted}} class="deleted"{{end}}><a href="{{.Href}}">{{.Name}}</a> ({{.Messages}} messages){{if .Deleted}} (deleted){{end}}</li>
+{{end}}</ul>
+{{end}}</main>
+</body>
+</html>
+`))
+
+const attachments = `{{define "attachments"}}{{range .}}<div class="attachment">{{if .Image}}<a href="{{.Href}}"><img src="{{.Href}}" alt="{{.Name}}" loading="lazy"></a>{{else if .Video}}<video src="{{.Href}}" controls preload="none"></video>{{else}}<a href="{{.Href}}">{{.Name}}</a> ({{.Size}}){{end}}</div>
+{{end}}{{end}}`
+
+const embeds = `{{define "embeds"}}{{range .}}<div class="embed"{{if .Color}} style="border-left-color: {{.Color}}"{{end}}>
+{{if .Thumbnail}}<img class="thumbnail" src="{{.Thumbnail}}" alt="" loading="lazy">{{end}}
+{{if .Author}}<div>{{if .AuthorIcon}}<img class="icon" src="{{.AuthorIcon}}" alt="">{{end}}{{.Author}}</div>{{end}}
+{{if .Title}}<div class="title">{{if .URL}}<a href="{{.URL}}">{{.Title}}</a>{{else}}{{.Title}}{{end}}</div>{{end}}
+{{if .Description}}<div>{{.Description}}</div>{{end}}
+{{range .Fields}}<div class="field"><div class="field-name">{{.Name}}</div><div>{{.Value}}</div></div>{{end}}
+{{if .Image}}<a href="{{.Image}}"><img class="image" src="{{.Image}}" alt="" loading="lazy"></a>{{end}}
+{{if .Video}}<video src="{{.Video}}" controls preload="none"></video>{{end}}
+{{if .Footer}}<div class="footer">{{if .FooterIcon}}<img class="icon" src="{{.FooterIcon}}" alt="">{{end}}{{.Footer}}</div>{{end}}
+</div>
+{{end}}{{end}}`
+
+const pages = `{{define "pages"}}{{if gt (len .) 1}}<nav class="pages">Page {{range .}}{{if .Current}}<span>{{.N}}</span>{{else}}<a href="{{.Href}}">{{.N}}</a>{{end}} {{end}}</nav>{{end}}{{end}}`
+
+var channelTemplate = template.Must(template.New("channel").Parse(head + attachments + embeds + pages + `{{template "head" .}}<header><h1>{{.Channel}}</h1> <a href="{{.GuildURL}}">{{.Guild}}</a>{{if .Topic}} <span class="topic">{{.Topic}}</span>{{end}}</header>
+{{template "pages" .Pages}}
+<main>
+{{range .Messages}}<div class="message" id="m{{.ID}}">
+{{if .Avatar}}<img class="avatar" src="{{.Avatar}}" alt="" loading="lazy">{{else}}<div class="avatar"></div>{{end}}
+<div class="body">
+{{with .Reply}}<div class="reply">↱ {{if .Href}}<a href="{{.Href}}">{{end}}{{if .Author}}<b>{{.Author}}</b> {{end}}{{.Excerpt}}{{if .Href}}</a>{{end}}</div>{{end}}
+<div><span class="author" title="{{.AuthorID}}">{{.Author}}</span>{{if .Bot}}<span class="bot">BOT</span>{{end}}<a class="time" href="#m{{.ID}}">{{.Time}}</a>{{if .Edited}}<span class="edited" title="{{.Edited}}">(edited)</span>{{end}}{{if .Deleted}}<span class="deleted-label">deleted</span>{{end}}{{if .System}}<span class="system">{{.System}}</span>{{end}}</div>
+{{if .Content}}<div class="content">{{.Content}}</div>{{end}}
+{{with .Forward}}<div class="forward"><div class="label">Forwarded{{if .Time}} · {{.Time}}{{end}}</div>{{if .Content}}<div class="content">{{.Content}}</div>{{end}}{{template "attachments" .Attachments}}{{template "embeds" .Embeds}}</div>{{end}}
+{{template "attachments" .Attachments}}
+{{template "embeds" .Embeds}}
+{{with .Poll}}<div class="poll"><div class="question">{{.Question}}</div>
+{{range .Answers}}<div class="answer"><span>{{if .Image}}<img src="{{.Image}}" alt="">{{else}}{{.Emoji}}{{end}} {{.Text}}</span><span>{{.Votes}}</span></div>
+{{end}}{{if .Final}}<div class="system">final results</div>{{end}}</div>{{end}}
+{{if .Reactions}}<div class="reactions">{{range .Reactions}}<span class="reaction" title="{{.Emoji}}">{{if .Image}}<img src="{{.Image}}" alt="{{.Emoji}}">{{else}}{{.Emoji}}{{end}} {{.Count}}</span>{{end}}</div>{{end}}
+</div>
+</div>
+{{end}}</main>
+{{template "pages" .Pages}}
+</body>
+</html>
+`))
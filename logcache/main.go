@@ -1,11 +1,11 @@
 package logcache
 
 import (
-	"bufio"
 	"io"
-	"os"
 
 	"github.com/tsudoko/pullcord/logentry"
+	"github.com/tsudoko/pullcord/logfile"
+	"github.com/tsudoko/pullcord/storage"
 	"github.com/tsudoko/pullcord/tsv"
 )
 
@@ -25,15 +25,11 @@ func (ec *Entries) IDs() IDs {
 	return ic
 }
 
-func NewEntries(fpath string, cache *Entries) error {
-	f, err := os.Open(fpath)
-	if err != nil {
-		return err
-	}
-
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		e := tsv.Read(scanner)
+func NewEntries(st storage.Storage, fpath string, cache *Entries) error {
+	return logfile.Decode(st, fpath, func(e []string) error {
+		if len(e) <= logentry.HID {
+			return logentry.ErrShort
+		}
 
 		switch e[logentry.HOp] {
 		case "add":
@@ -44,22 +40,26 @@ func NewEntries(fpath string, cache *Entries) error {
 		case "del":
 			delete((*cache)[e[logentry.HType]], e[logentry.HID])
 		}
-	}
-
-	return scanner.Err()
+		return nil
+	})
 }
 
-func (cache *Entries) WriteNew(w io.Writer, e []string) {
+// WriteNew writes e if it differs from the cached entry with the same ID.
+func (cache *Entries) WriteNew(w io.Writer, e []string) error {
 	cacheEntry := (*cache)[e[logentry.HType]][e[logentry.HID]]
 
 	if len(cacheEntry) < logentry.HTime+1 || len(e) < logentry.HTime+1 ||
 		!entryEquals(cacheEntry[logentry.HTime+1:], e[logentry.HTime+1:]) {
-		tsv.Write(w, e)
+		if err := tsv.NewEncoder(w).Encode(e); err != nil {
+			return err
+		}
 		if (*cache)[e[logentry.HType]] == nil {
 			(*cache)[e[logentry.HType]] = make(map[string][]string)
 		}
 		(*cache)[e[logentry.HType]][e[logentry.HID]] = e
 	}
+
+	return nil
 }
 
 func entryEquals(a, b []string) bool {
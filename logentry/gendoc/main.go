@@ -0,0 +1,64 @@
+// Command gendoc updates the field reference in FORMAT.md from the schemas in
+// logentry. It's run by go generate.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/tsudoko/pullcord/logentry"
+)
+
+const (
+	begin = "<!-- BEGIN FIELD REFERENCE, generated by go generate in logentry -->\n"
+	end   = "<!-- END FIELD REFERENCE -->\n"
+)
+
+func table(b *bytes.Buffer, fields []logentry.Field) {
+	b.WriteString("| field | kind | required | since |\n")
+	b.WriteString("|-------|------|----------|-------|\n")
+	for _, f := range fields {
+		req := ""
+		if f.Required {
+			req = "yes"
+		}
+		fmt.Fprintf(b, "| `%s` | %v | %s | %d |\n", f.Name, f.Kind, req, f.Since)
+	}
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("usage: gendoc FORMAT.md")
+	}
+
+	doc, err := os.ReadFile(os.Args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	i := bytes.Index(doc, []byte(begin))
+	j := bytes.Index(doc, []byte(end))
+	if i == -1 || j < i {
+		log.Fatal("field reference markers not found")
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "\nCurrent format version: %d\n\n", logentry.Version)
+	b.WriteString("Common fields:\n\n")
+	table(&b, logentry.Common)
+	for _, s := range logentry.Schemas {
+		fmt.Fprintf(&b, "\n### `%s` fields\n\n", s.Type)
+		fmt.Fprintf(&b, "    %s\n\n", s.Header())
+		fmt.Fprintf(&b, "Since version %d.\n\n", s.Since)
+		table(&b, s.Fields)
+	}
+	b.WriteString("\n")
+
+	out := strings.Join([]string{string(doc[:i+len(begin)]), b.String(), string(doc[j:])}, "")
+	if err := os.WriteFile(os.Args[1], []byte(out), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -2,12 +2,10 @@
 package logentry
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"sort"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -23,9 +21,13 @@ const (
 	HID
 )
 
+// ErrShort is returned for records without all the common fields.
+var ErrShort = errors.New("record has fewer fields than the header")
+
 type Attachment struct {
 	discordgo.MessageAttachment
 	MessageID string
+	Snapshot  bool
 }
 
 type Reaction struct {
@@ -36,6 +38,46 @@ type Reaction struct {
 type Embed struct {
 	discordgo.MessageEmbed
 	MessageID string
+	Snapshot  bool
+}
+
+// EmbedMedia maps a file referenced by an embed to its local copy.
+type EmbedMedia struct {
+	MessageID string
+	Kind      string // thumbnail, image, authoricon, footericon or video
+	URL       string
+	ProxyURL  string
+	Path      string
+}
+
+type Poll struct {
+	discordgo.Poll
+	MessageID string
+}
+
+type PollAnswer struct {
+	discordgo.PollAnswer
+	MessageID string
+}
+
+type PollResult struct {
+	discordgo.PollAnswerCount
+	MessageID string
+	Finalized bool
+}
+
+type PollVote struct {
+	UserID    string
+	MessageID string
+	AnswerID  int
+	Count     int
+}
+
+// Snapshot is a copy of a forwarded message, MessageID is the ID of the
+// forwarding message.
+type Snapshot struct {
+	discordgo.Message
+	MessageID string
 }
 
 func idsFromUsers(users []*discordgo.User) (ids []string) {
@@ -53,6 +95,16 @@ func formatBool(name string, variable bool) string {
 	}
 }
 
+func formatPollEmoji(e *discordgo.ComponentEmoji) string {
+	if e == nil {
+		return ""
+	}
+	if e.ID != "" {
+		return e.Name + ":" + e.ID
+	}
+	return e.Name
+}
+
 func formatMessageType(t discordgo.MessageType) string {
 	switch t {
 	case discordgo.MessageTypeDefault:
@@ -73,7 +125,7 @@ func formatMessageType(t discordgo.MessageType) string {
 		return "guild_member_join"
 	case discordgo.MessageTypeReply:
 		return "reply"
-	case discordgo.MessageTypeApplicationCommand:
+	case discordgo.MessageTypeChatInputCommand:
 		return "application_command"
 	default:
 		log.Printf("unsupported message type %v", t)
@@ -98,8 +150,9 @@ func formatChannelType(t discordgo.ChannelType) string {
 	case discordgo.ChannelTypeGuildStore:
 		return "store"
 	default:
-		log.Panicf("unsupported channel type %v", t)
-		return "invalid"
+		// e.g. forum, media and stage channels
+		log.Printf("unsupported channel type %v", t)
+		return fmt.Sprintf("unknown-%v", t)
 	}
 }
 
@@ -129,6 +182,18 @@ func Type(v interface{}) string {
 		return "reaction"
 	case *Embed:
 		return "embed"
+	case *EmbedMedia:
+		return "embedmedia"
+	case *Poll:
+		return "poll"
+	case *PollAnswer:
+		return "pollanswer"
+	case *PollResult:
+		return "pollresult"
+	case *PollVote:
+		return "pollvote"
+	case *Snapshot:
+		return "snapshot"
 	case *discordgo.Guild:
 		return "guild"
 	case *discordgo.Member:
@@ -146,111 +211,147 @@ func Type(v interface{}) string {
 	}
 }
 
+// discordTime returns a timestamp sent by Discord in UTC, so it's logged the
+// way Discord sent it, e.g. 2017-06-24T13:06:38.555000+00:00.
+func discordTime(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.UTC()
+}
+
 func Make(ftype, op string, v interface{}) []string {
-	var row []string
+	r := Record{Time: time.Now(), FetchType: ftype, Op: op, Type: Type(v)}
+	var rec interface{}
 
 	switch v := v.(type) {
 	case *discordgo.Message:
-		ref := []string{"", "", ""}
-		if v.MessageReference != nil {
-			ref[0] = v.MessageReference.GuildID
-			ref[1] = v.MessageReference.ChannelID
-			ref[2] = v.MessageReference.MessageID
-		}
-		row = []string{
-			v.ID,
-			v.Author.ID,
-			string(v.EditedTimestamp),
-			formatBool("tts", v.TTS),
-			v.Content,
-			formatBool("webhook", v.WebhookID != ""),
-			v.Author.Username,
-			v.Author.Avatar,
-			formatMessageType(v.Type),
-			ref[0],
-			ref[1],
-			ref[2],
+		m := &MessageRecord{
+			Record:      r,
+			ID:          v.ID,
+			AuthorID:    v.Author.ID,
+			EditedTime:  discordTime(v.EditedTimestamp),
+			TTS:         v.TTS,
+			Content:     v.Content,
+			Webhook:     v.WebhookID != "",
+			MessageType: formatMessageType(v.Type),
 		}
 		// only webhooks can override username/avatar at the moment
-		if v.WebhookID == "" {
-			row[6] = ""
-			row[7] = ""
+		if v.WebhookID != "" {
+			m.UsernameOverride = v.Author.Username
+			m.AvatarOverride = v.Author.Avatar
+		}
+		if v.MessageReference != nil {
+			m.RefGuildID = v.MessageReference.GuildID
+			m.RefChannelID = v.MessageReference.ChannelID
+			m.RefMessageID = v.MessageReference.MessageID
 		}
+		rec = m
 	case *Attachment:
-		row = []string{v.ID, v.MessageID, v.Filename}
+		rec = &AttachmentRecord{r, v.ID, v.MessageID, v.Filename, v.Snapshot, v.Size, v.ContentType}
 	case *Reaction:
-		row = []string{
-			v.UserID,
+		rec = &ReactionRecord{r, v.UserID, v.MessageID, v.Emoji.APIName(), v.Count}
+	case *Embed:
+		rec = &EmbedRecord{r, v.MessageID, v.MessageEmbed, v.Snapshot}
+	case *EmbedMedia:
+		rec = &EmbedMediaRecord{r, v.MessageID, v.Kind, v.URL, v.ProxyURL, v.Path}
+	case *Poll:
+		rec = &PollRecord{
+			r,
 			v.MessageID,
-			v.Emoji.APIName(),
-			strconv.Itoa(v.Count),
+			v.Question.Text,
+			discordTime(v.Expiry),
+			v.AllowMultiselect,
+			int(v.LayoutType),
 		}
-	case *Embed:
-		j, err := json.Marshal(v.MessageEmbed)
-		if err != nil {
-			panic(err)
+	case *PollAnswer:
+		a := &PollAnswerRecord{Record: r, ID: v.AnswerID, MessageID: v.MessageID}
+		if v.Media != nil {
+			a.Text = v.Media.Text
+			a.Emoji = formatPollEmoji(v.Media.Emoji)
+		}
+		rec = a
+	case *PollResult:
+		rec = &PollResultRecord{r, v.ID, v.MessageID, v.Count, v.Finalized}
+	case *PollVote:
+		rec = &PollVoteRecord{r, v.UserID, v.MessageID, v.AnswerID, v.Count}
+	case *Snapshot:
+		rec = &SnapshotRecord{
+			r,
+			v.MessageID,
+			formatMessageType(v.Type),
+			discordTime(&v.Timestamp),
+			discordTime(v.EditedTimestamp),
+			v.Content,
+			int(v.Flags),
 		}
-
-		row = []string{v.MessageID, string(j)}
 	case *discordgo.Guild:
-		row = []string{
+		rec = &GuildRecord{
+			r,
 			v.ID,
 			v.Name,
 			v.Icon,
 			v.Splash,
 			v.OwnerID,
 			v.AfkChannelID,
-			strconv.Itoa(v.AfkTimeout),
-			formatBool("embeddable", v.WidgetEnabled),
+			v.AfkTimeout,
+			v.WidgetEnabled,
 			v.WidgetChannelID,
+			v.Banner,
+			v.DiscoverySplash,
 		}
 	case *discordgo.Member:
 		sort.StringSlice(v.Roles).Sort()
-		row = []string{
+		rec = &MemberRecord{
+			r,
 			v.User.ID,
 			v.User.Username,
 			v.User.Discriminator,
 			v.User.Avatar,
 			v.Nick,
-			strings.Join(v.Roles, ","),
+			v.Roles,
+			v.Avatar,
+			v.User.Banner,
+			v.Banner,
 		}
 	case *discordgo.Role:
-		row = []string{
+		rec = &RoleRecord{
+			r,
 			v.ID,
 			v.Name,
-			strconv.Itoa(v.Color),
-			strconv.Itoa(v.Position),
-			strconv.FormatInt(v.Permissions, 10),
-			formatBool("hoist", v.Hoist),
+			v.Color,
+			v.Position,
+			v.Permissions,
+			v.Hoist,
+			v.Icon,
+			v.UnicodeEmoji,
 		}
 	case *discordgo.Channel:
-		row = []string{
+		rec = &ChannelRecord{
+			r,
 			v.ID,
 			formatChannelType(v.Type),
-			strconv.Itoa(v.Position),
+			v.Position,
 			v.Name,
 			v.Topic,
-			formatBool("nsfw", v.NSFW),
+			v.NSFW,
 			v.ParentID,
-			strings.Join(idsFromUsers(v.Recipients), ","),
+			idsFromUsers(v.Recipients),
 			v.Icon,
 		}
 	case *discordgo.PermissionOverwrite:
-		row = []string{
+		rec = &PermOverwriteRecord{
+			r,
 			v.ID,
 			formatPermOverwriteType(v.Type),
-			strconv.FormatInt(v.Allow, 10),
-			strconv.FormatInt(v.Deny, 10),
+			v.Allow,
+			v.Deny,
 		}
 	case *discordgo.Emoji:
-		row = []string{
-			v.ID,
-			v.Name,
-			formatBool("nocolons", !v.RequireColons),
-		}
+		rec = &EmojiRecord{r, v.ID, v.Name, !v.RequireColons}
 	default:
 		panic("unsupported type")
 	}
 
-	return append([]string{Timestamp(), ftype, op, Type(v)}, row...)
+	return Format(rec)
 }
@@ -0,0 +1,127 @@
+package logentry
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestFormatChannelType(t *testing.T) {
+	tests := []struct {
+		t    discordgo.ChannelType
+		want string
+	}{
+		{discordgo.ChannelTypeGuildText, "text"},
+		{discordgo.ChannelTypeGuildStore, "store"},
+		{discordgo.ChannelTypeGuildForum, "unknown-15"},
+		{discordgo.ChannelTypeGuildStageVoice, "unknown-13"},
+	}
+	for _, tt := range tests {
+		if got := formatChannelType(tt.t); got != tt.want {
+			t.Errorf("formatChannelType(%d) = %q, want %q", tt.t, got, tt.want)
+		}
+	}
+}
+
+func timeIn(s string) *time.Time {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		panic(err)
+	}
+	return &t
+}
+
+// makeTests hold the records written by Make before it went through Format,
+// without the time field. Timestamps from Discord are in UTC, as it sends
+// them.
+var makeTests = []struct {
+	v    interface{}
+	want string
+}{
+	{&discordgo.Message{
+		ID:              "10",
+		Author:          &discordgo.User{ID: "1", Username: "hook", Avatar: "av"},
+		EditedTimestamp: timeIn("2017-06-24T15:06:38.555+02:00"),
+		TTS:             true,
+		Content:         "a\tb",
+		WebhookID:       "5",
+		Type:            discordgo.MessageTypeReply,
+		MessageReference: &discordgo.MessageReference{
+			GuildID:   "2",
+			ChannelID: "3",
+			MessageID: "4",
+		},
+	}, "history add message 10 1 2017-06-24T13:06:38.555000+00:00 tts a\tb webhook hook av reply 2 3 4"},
+	{&discordgo.Message{ID: "11", Author: &discordgo.User{ID: "1", Username: "user", Avatar: "av"}},
+		"history add message 11 1" + strings.Repeat(" ", 10)},
+	{&Attachment{discordgo.MessageAttachment{ID: "20", Filename: "a.png", Size: 3, ContentType: "image/png"}, "10", true},
+		"history add attachment 20 10 a.png snapshot 3 image/png"},
+	{&Reaction{discordgo.MessageReaction{UserID: "1", MessageID: "10", Emoji: discordgo.Emoji{ID: "30", Name: "x"}}, 2},
+		"history add reaction 1 10 x:30 2"},
+	{&Embed{discordgo.MessageEmbed{Title: "t"}, "10", false},
+		`history add embed 10 {"title":"t"} `},
+	{&EmbedMedia{"10", "image", "https://example.com/a.png", "", "hosts/example.com/a.png"},
+		"history add embedmedia 10 image https://example.com/a.png  hosts/example.com/a.png"},
+	{&Poll{discordgo.Poll{
+		Question:         discordgo.PollMedia{Text: "q"},
+		Expiry:           timeIn("2020-01-01T00:00:00Z"),
+		AllowMultiselect: true,
+		LayoutType:       discordgo.PollLayoutTypeDefault,
+	}, "10"}, "history add poll 10 q 2020-01-01T00:00:00.000000+00:00 multiselect 1"},
+	{&PollAnswer{discordgo.PollAnswer{AnswerID: 1, Media: &discordgo.PollMedia{Text: "a", Emoji: &discordgo.ComponentEmoji{Name: "e", ID: "31"}}}, "10"},
+		"history add pollanswer 1 10 a e:31"},
+	{&PollAnswer{discordgo.PollAnswer{AnswerID: 2}, "10"},
+		"history add pollanswer 2 10  "},
+	{&PollResult{discordgo.PollAnswerCount{ID: 1, Count: 4}, "10", true},
+		"history add pollresult 1 10 4 finalized"},
+	{&PollVote{"1", "10", 2, 1},
+		"history add pollvote 1 10 2 1"},
+	{&Snapshot{discordgo.Message{
+		Timestamp:       *timeIn("2017-06-24T13:06:38.555Z"),
+		EditedTimestamp: timeIn("2017-06-24T14:00:00.000001+01:00"),
+		Content:         "fwd",
+		Flags:           discordgo.MessageFlagsCrossPosted,
+	}, "10"}, "history add snapshot 10  2017-06-24T13:06:38.555000+00:00 2017-06-24T13:00:00.000001+00:00 fwd 1"},
+	{&discordgo.Guild{
+		ID:              "2",
+		Name:            "g",
+		Icon:            "i",
+		OwnerID:         "1",
+		AfkTimeout:      300,
+		WidgetEnabled:   true,
+		WidgetChannelID: "3",
+		DiscoverySplash: "d",
+	}, "history add guild 2 g i  1  300 embeddable 3  d"},
+	{&discordgo.Member{
+		User:  &discordgo.User{ID: "1", Username: "u", Discriminator: "0001", Avatar: "a", Banner: "b"},
+		Nick:  "n",
+		Roles: []string{"6", "5"},
+	}, "history add member 1 u 0001 a n 5,6  b "},
+	{&discordgo.Role{ID: "5", Name: "r", Color: 0xff0000, Position: 2, Permissions: 1 << 40, Hoist: true, UnicodeEmoji: "x"},
+		"history add role 5 r 16711680 2 1099511627776 hoist  x"},
+	{&discordgo.Channel{
+		ID:         "3",
+		Type:       discordgo.ChannelTypeGroupDM,
+		Name:       "c",
+		NSFW:       true,
+		Recipients: []*discordgo.User{{ID: "1"}, {ID: "7"}},
+	}, "history add channel 3 groupdm 0 c  nsfw  1,7 "},
+	{&discordgo.PermissionOverwrite{ID: "5", Type: discordgo.PermissionOverwriteTypeRole, Allow: 1024, Deny: 2048},
+		"history add permoverwrite 5 role 1024 2048"},
+	{&discordgo.Emoji{ID: "30", Name: "x", RequireColons: false},
+		"history add emoji 30 x nocolons"},
+}
+
+func TestMake(t *testing.T) {
+	for _, tt := range makeTests {
+		e := Make("history", "add", tt.v)
+		if _, err := time.Parse(time.RFC3339, e[HTime]); err != nil {
+			t.Errorf("%s: %v", e[HType], err)
+		}
+		if got := strings.Join(e[1:], " "); got != tt.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", e[HType], got, tt.want)
+		}
+	}
+}
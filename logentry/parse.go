@@ -0,0 +1,338 @@
+package logentry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Record holds the fields common to all records. Fields missing from records
+// written by older versions are left empty by Parse.
+type Record struct {
+	Time      time.Time `log:"time"`
+	FetchType string    `log:"fetchtype"`
+	Op        string    `log:"action"`
+	Type      string    `log:"type"`
+}
+
+type MessageRecord struct {
+	Record
+	ID               string    `log:"id"`
+	AuthorID         string    `log:"authorid"`
+	EditedTime       time.Time `log:"editedtime"`
+	TTS              bool      `log:"tts"`
+	Content          string    `log:"content"`
+	Webhook          bool      `log:"webhook"`
+	UsernameOverride string    `log:"usernameoverride"`
+	AvatarOverride   string    `log:"avataroverride"`
+	MessageType      string    `log:"msgtype"`
+	RefGuildID       string    `log:"refguildid"`
+	RefChannelID     string    `log:"refchanid"`
+	RefMessageID     string    `log:"refmsgid"`
+}
+
+type AttachmentRecord struct {
+	Record
+	ID          string `log:"id"`
+	MessageID   string `log:"messageid"`
+	Filename    string `log:"filename"`
+	Snapshot    bool   `log:"snapshot"`
+	Size        int    `log:"size"`
+	ContentType string `log:"contenttype"`
+}
+
+type ReactionRecord struct {
+	Record
+	UserID    string `log:"userid"`
+	MessageID string `log:"messageid"`
+	Emoji     string `log:"emoji"`
+	Count     int    `log:"count"`
+}
+
+type EmbedRecord struct {
+	Record
+	MessageID string                 `log:"messageid"`
+	Embed     discordgo.MessageEmbed `log:"json"`
+	Snapshot  bool                   `log:"snapshot"`
+}
+
+type EmbedMediaRecord struct {
+	Record
+	MessageID string `log:"messageid"`
+	Kind      string `log:"kind"`
+	URL       string `log:"url"`
+	ProxyURL  string `log:"proxyurl"`
+	Path      string `log:"path"`
+}
+
+type SnapshotRecord struct {
+	Record
+	ID          string    `log:"id"`
+	MessageType string    `log:"msgtype"`
+	Timestamp   time.Time `log:"timestamp"`
+	EditedTime  time.Time `log:"editedtime"`
+	Content     string    `log:"content"`
+	Flags       int       `log:"flags"`
+}
+
+type PollRecord struct {
+	Record
+	ID          string    `log:"id"`
+	Question    string    `log:"question"`
+	Expiry      time.Time `log:"expiry"`
+	Multiselect bool      `log:"multiselect"`
+	Layout      int       `log:"layout"`
+}
+
+type PollAnswerRecord struct {
+	Record
+	ID        int    `log:"id"`
+	MessageID string `log:"messageid"`
+	Text      string `log:"text"`
+	Emoji     string `log:"emoji"`
+}
+
+type PollResultRecord struct {
+	Record
+	ID        int    `log:"id"`
+	MessageID string `log:"messageid"`
+	Count     int    `log:"count"`
+	Finalized bool   `log:"finalized"`
+}
+
+type PollVoteRecord struct {
+	Record
+	UserID    string `log:"userid"`
+	MessageID string `log:"messageid"`
+	AnswerID  int    `log:"answerid"`
+	Count     int    `log:"count"`
+}
+
+type PinRecord struct {
+	Record
+	MessageID string `log:"messageid"`
+}
+
+type GuildRecord struct {
+	Record
+	ID              string `log:"id"`
+	Name            string `log:"name"`
+	Icon            string `log:"icon"`
+	Splash          string `log:"splash"`
+	OwnerID         string `log:"ownerid"`
+	AFKChannelID    string `log:"afkchanid"`
+	AFKTimeout      int    `log:"afktimeout"`
+	Embeddable      bool   `log:"embeddable"`
+	EmbedChannelID  string `log:"embedchanid"`
+	Banner          string `log:"banner"`
+	DiscoverySplash string `log:"discoverysplash"`
+}
+
+type MemberRecord struct {
+	Record
+	UserID        string   `log:"userid"`
+	Username      string   `log:"username"`
+	Discriminator string   `log:"discriminator"`
+	Avatar        string   `log:"avatar"`
+	Nick          string   `log:"nick"`
+	Roles         []string `log:"roles"`
+	GuildAvatar   string   `log:"guildavatar"`
+	Banner        string   `log:"banner"`
+	GuildBanner   string   `log:"guildbanner"`
+}
+
+type BanRecord struct {
+	Record
+	UserID string `log:"userid"`
+	Reason string `log:"reason"`
+}
+
+type RoleRecord struct {
+	Record
+	ID           string `log:"id"`
+	Name         string `log:"name"`
+	Color        int    `log:"color"`
+	Position     int    `log:"pos"`
+	Permissions  int64  `log:"perms"`
+	Hoist        bool   `log:"hoist"`
+	Icon         string `log:"icon"`
+	UnicodeEmoji string `log:"unicodeemoji"`
+}
+
+type ChannelRecord struct {
+	Record
+	ID           string   `log:"id"`
+	ChannelType  string   `log:"chantype"`
+	Position     int      `log:"pos"`
+	Name         string   `log:"name"`
+	Topic        string   `log:"topic"`
+	NSFW         bool     `log:"nsfw"`
+	CategoryID   string   `log:"category"`
+	RecipientIDs []string `log:"recipients"`
+	Icon         string   `log:"icon"`
+}
+
+type PermOverwriteRecord struct {
+	Record
+	ID            string `log:"id"`
+	OverwriteType string `log:"overwritetype"`
+	Allow         int64  `log:"allow"`
+	Deny          int64  `log:"deny"`
+}
+
+type EmojiRecord struct {
+	Record
+	ID       string `log:"id"`
+	Name     string `log:"name"`
+	NoColons bool   `log:"nocolons"`
+}
+
+// UnknownTypeError is returned by Parse for records of types it doesn't know.
+type UnknownTypeError string
+
+func (e UnknownTypeError) Error() string {
+	return fmt.Sprintf("unknown record type %q", string(e))
+}
+
+// FieldError is returned by Parse for fields which can't be decoded.
+type FieldError struct {
+	Type  string
+	Field string
+	Value string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s field %s (%q): %v", e.Type, e.Field, e.Value, e.Err)
+}
+
+// errEmpty is the FieldError.Err of required fields which are empty.
+var errEmpty = errors.New("required field is empty")
+
+func formatField(f Field, v reflect.Value) string {
+	switch f.Kind {
+	case KindBool:
+		return formatBool(f.Name, v.Bool())
+	case KindInt:
+		return strconv.FormatInt(v.Int(), 10)
+	case KindTime:
+		t := v.Interface().(time.Time)
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(timeFormat)
+	case KindList:
+		return strings.Join(v.Interface().([]string), ",")
+	case KindJSON:
+		j, err := json.Marshal(v.Interface())
+		if err != nil {
+			panic(err)
+		}
+		return string(j)
+	default:
+		return v.String()
+	}
+}
+
+func parseField(f Field, s string, v reflect.Value) error {
+	if s == "" {
+		return nil
+	}
+
+	switch f.Kind {
+	case KindBool:
+		v.SetBool(true)
+	case KindInt:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case KindTime:
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+	case KindList:
+		v.Set(reflect.ValueOf(strings.Split(s, ",")))
+	case KindJSON:
+		return json.Unmarshal([]byte(s), v.Addr().Interface())
+	default:
+		v.SetString(s)
+	}
+	return nil
+}
+
+// Header returns the common fields of one of the *Record types above.
+func Header(rec interface{}) *Record {
+	return reflect.ValueOf(rec).Elem().Field(0).Addr().Interface().(*Record)
+}
+
+// Format encodes one of the *Record types above as described by its schema.
+func Format(rec interface{}) []string {
+	v := reflect.ValueOf(rec).Elem()
+	s := recordTypes[v.Type()]
+	if s == nil {
+		panic("unsupported type")
+	}
+
+	row := make([]string, 0, len(Common)+len(s.Fields))
+	for i, f := range Common {
+		row = append(row, formatField(f, v.Field(0).Field(commonIndex[i])))
+	}
+	for i, f := range s.Fields {
+		row = append(row, formatField(f, v.Field(s.index[i])))
+	}
+	return row
+}
+
+// Parse decodes a record into one of the *Record types above, e.g. a message
+// record into a *MessageRecord. Trailing fields missing from records written
+// by older versions are left empty, extra ones written by newer versions are
+// ignored.
+func Parse(e []string) (interface{}, error) {
+	if len(e) <= HID {
+		return nil, ErrShort
+	}
+
+	s := schemas[e[HType]]
+	if s == nil {
+		return nil, UnknownTypeError(e[HType])
+	}
+
+	v := reflect.New(s.record).Elem()
+	parse := func(f Field, n int, fv reflect.Value) error {
+		var field string
+		if n < len(e) {
+			field = e[n]
+		}
+		err := parseField(f, field, fv)
+		if err == nil && f.Required && field == "" {
+			err = errEmpty
+		}
+		if err != nil {
+			return &FieldError{s.Type, f.Name, field, err}
+		}
+		return nil
+	}
+
+	for i, f := range Common {
+		if err := parse(f, i, v.Field(0).Field(commonIndex[i])); err != nil {
+			return nil, err
+		}
+	}
+	for i, f := range s.Fields {
+		if err := parse(f, len(Common)+i, v.Field(s.index[i])); err != nil {
+			return nil, err
+		}
+	}
+
+	return v.Addr().Interface(), nil
+}
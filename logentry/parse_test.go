@@ -0,0 +1,127 @@
+package logentry
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testTime = "2020-01-02T03:04:05.000006+01:00"
+
+// roundTripTests hold a record of every type with all fields set, separated
+// with spaces after the time field.
+var roundTripTests = []string{
+	"history add message 10 1 2017-06-24T13:06:38.555000+00:00 tts content webhook hook av reply 2 3 4",
+	"history add attachment 20 10 a.png snapshot 3 image/png",
+	"realtime del reaction 1 10 x:30 2",
+	`history add embed 10 {"type":"rich","title":"t","fields":[{"name":"n","value":"v"}]} snapshot`,
+	"history add embedmedia 10 image https://example.com/a.png https://media.discordapp.net/a.png hosts/example.com/a.png",
+	"history add snapshot 10 reply 2017-06-24T13:06:38.555000+00:00 2017-06-24T13:07:00.000000+00:00 fwd 16384",
+	"history add poll 10 q 2020-01-01T00:00:00.000000+00:00 multiselect 1",
+	"history add pollanswer 1 10 a e:31",
+	"history add pollresult 1 10 4 finalized",
+	"history add pollvote 1 10 2 1",
+	"history add pin 10",
+	"history add guild 2 g i s 1 3 300 embeddable 3 b d",
+	"history add member 1 u 0001 a n 5,6 ga b gb",
+	"history add ban 1 spam",
+	"history add role 5 r 16711680 2 1099511627776 hoist i x",
+	"history add channel 3 groupdm 1 c topic nsfw 4 1,7 i",
+	"history add permoverwrite 5 role 1024 2048",
+	"history add emoji 30 x nocolons",
+}
+
+func TestRoundTrip(t *testing.T) {
+	tested := make(map[string]bool)
+	for _, tt := range roundTripTests {
+		e := append([]string{testTime}, strings.Split(tt, " ")...)
+		rec, err := Parse(e)
+		if err != nil {
+			t.Errorf("%s: %v", e[HType], err)
+			continue
+		}
+		if got := Format(rec); !reflect.DeepEqual(got, e) {
+			t.Errorf("%s:\ngot  %q\nwant %q", e[HType], got, e)
+		}
+		if h := Header(rec); h.Type != e[HType] || h.Op != e[HOp] || h.Time.Format(timeFormat) != testTime {
+			t.Errorf("%s: header %+v", e[HType], h)
+		}
+		tested[e[HType]] = true
+	}
+
+	for _, s := range Schemas {
+		if !tested[s.Type] {
+			t.Errorf("%s records aren't tested", s.Type)
+		}
+	}
+}
+
+func TestRoundTripMake(t *testing.T) {
+	for _, tt := range makeTests {
+		e := Make("history", "add", tt.v)
+		rec, err := Parse(e)
+		if err != nil {
+			t.Errorf("%s: %v", e[HType], err)
+			continue
+		}
+		if got := Format(rec); !reflect.DeepEqual(got, e) {
+			t.Errorf("%s:\ngot  %q\nwant %q", e[HType], got, e)
+		}
+	}
+}
+
+func TestParseOlderAndNewer(t *testing.T) {
+	// fields added later are empty in older records, ints are written as 0
+	old := []string{testTime, "history", "add", "attachment", "20", "10", "a.png"}
+	rec, err := Parse(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := rec.(*AttachmentRecord)
+	if a.Filename != "a.png" || a.Snapshot || a.Size != 0 || a.ContentType != "" {
+		t.Errorf("older record: got %+v", a)
+	}
+	if got, want := Format(rec), append(old, "", "0", ""); !reflect.DeepEqual(got, want) {
+		t.Errorf("older record: got %q, want %q", got, want)
+	}
+
+	// fields added by newer versions are ignored
+	newer := []string{testTime, "history", "add", "pin", "10", "extra"}
+	rec, err = Parse(newer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := Format(rec), newer[:5]; !reflect.DeepEqual(got, want) {
+		t.Errorf("newer record: got %q, want %q", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		e     string
+		field string
+		err   error
+	}{
+		{"history add role 5 r red 2 0", "color", nil},
+		{"history add role 5  1 2 0", "name", errEmpty},
+		{"history add pin ", "messageid", errEmpty},
+		{"history add message 10 1 yesterday", "editedtime", nil},
+		{"history add embed 10 {", "json", nil},
+		{"history  message 10 1", "action", errEmpty},
+	}
+	for _, tt := range tests {
+		_, err := Parse(append([]string{testTime}, strings.Split(tt.e, " ")...))
+		var ferr *FieldError
+		if !errors.As(err, &ferr) || ferr.Field != tt.field || (tt.err != nil && ferr.Err != tt.err) {
+			t.Errorf("%q: got %v, want an error for %s", tt.e, err, tt.field)
+		}
+	}
+
+	if _, err := Parse([]string{testTime, "history", "add", "role"}); err != ErrShort {
+		t.Errorf("short record: got %v", err)
+	}
+	if _, err := Parse([]string{testTime, "history", "add", "sticker", "1"}); err != UnknownTypeError("sticker") {
+		t.Errorf("unknown type: got %v", err)
+	}
+}
@@ -0,0 +1,293 @@
+package logentry
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+//go:generate go run ./gendoc ../FORMAT.md
+
+// Version is the version of the log format written by this version of
+// pullcord. It's increased whenever a record type or a field is added or the
+// layout of the archive changes.
+const Version = 6
+
+type Kind int
+
+const (
+	KindString Kind = iota
+	KindBool        // contains the field name if true, empty otherwise
+	KindInt
+	KindTime // ISO 8601 with microseconds
+	KindList // comma-separated
+	KindJSON
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindString:
+		return "string"
+	case KindBool:
+		return "bool"
+	case KindInt:
+		return "int"
+	case KindTime:
+		return "time"
+	case KindList:
+		return "list"
+	case KindJSON:
+		return "json"
+	default:
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+}
+
+// Field describes a single field of a record.
+type Field struct {
+	Name     string
+	Kind     Kind
+	Required bool // if the field can't be empty
+	Since    int  // format version which introduced the field
+}
+
+// Schema describes the fields of a record type following the common ones.
+type Schema struct {
+	Type   string
+	Since  int // format version which introduced the type
+	Fields []Field
+
+	record reflect.Type
+	index  []int // index of the struct field of record for each field
+}
+
+// Common describes the fields common to all records.
+var Common = []Field{
+	{"time", KindTime, true, 1},
+	{"fetchtype", KindString, true, 1},
+	{"action", KindString, true, 1},
+	{"type", KindString, true, 1},
+}
+
+// Schemas lists all record types, in the order they're documented.
+var Schemas = []*Schema{
+	{Type: "message", Since: 1, record: reflect.TypeOf(MessageRecord{}), Fields: []Field{
+		{"id", KindString, true, 1},
+		{"authorid", KindString, true, 1},
+		{"editedtime", KindTime, false, 1},
+		{"tts", KindBool, false, 1},
+		{"content", KindString, false, 1},
+		{"webhook", KindBool, false, 1},
+		{"usernameoverride", KindString, false, 1},
+		{"avataroverride", KindString, false, 1},
+		{"msgtype", KindString, false, 1},
+		{"refguildid", KindString, false, 1},
+		{"refchanid", KindString, false, 1},
+		{"refmsgid", KindString, false, 1},
+	}},
+	{Type: "attachment", Since: 1, record: reflect.TypeOf(AttachmentRecord{}), Fields: []Field{
+		{"id", KindString, true, 1},
+		{"messageid", KindString, true, 1},
+		{"filename", KindString, false, 1},
+		{"snapshot", KindBool, false, 2},
+		{"size", KindInt, false, 5},
+		{"contenttype", KindString, false, 5},
+	}},
+	{Type: "reaction", Since: 1, record: reflect.TypeOf(ReactionRecord{}), Fields: []Field{
+		{"userid", KindString, false, 1},
+		{"messageid", KindString, true, 1},
+		{"emoji", KindString, true, 1},
+		{"count", KindInt, true, 1},
+	}},
+	{Type: "embed", Since: 1, record: reflect.TypeOf(EmbedRecord{}), Fields: []Field{
+		{"messageid", KindString, true, 1},
+		{"json", KindJSON, true, 1},
+		{"snapshot", KindBool, false, 2},
+	}},
+	{Type: "embedmedia", Since: 3, record: reflect.TypeOf(EmbedMediaRecord{}), Fields: []Field{
+		{"messageid", KindString, true, 3},
+		{"kind", KindString, true, 3},
+		{"url", KindString, false, 3},
+		{"proxyurl", KindString, false, 3},
+		{"path", KindString, true, 3},
+	}},
+	{Type: "snapshot", Since: 2, record: reflect.TypeOf(SnapshotRecord{}), Fields: []Field{
+		{"id", KindString, true, 2},
+		{"msgtype", KindString, false, 2},
+		{"timestamp", KindTime, true, 2},
+		{"editedtime", KindTime, false, 2},
+		{"content", KindString, false, 2},
+		{"flags", KindInt, false, 2},
+	}},
+	{Type: "poll", Since: 2, record: reflect.TypeOf(PollRecord{}), Fields: []Field{
+		{"id", KindString, true, 2},
+		{"question", KindString, true, 2},
+		{"expiry", KindTime, false, 2},
+		{"multiselect", KindBool, false, 2},
+		{"layout", KindInt, false, 2},
+	}},
+	{Type: "pollanswer", Since: 2, record: reflect.TypeOf(PollAnswerRecord{}), Fields: []Field{
+		{"id", KindInt, true, 2},
+		{"messageid", KindString, true, 2},
+		{"text", KindString, false, 2},
+		{"emoji", KindString, false, 2},
+	}},
+	{Type: "pollresult", Since: 2, record: reflect.TypeOf(PollResultRecord{}), Fields: []Field{
+		{"id", KindInt, true, 2},
+		{"messageid", KindString, true, 2},
+		{"count", KindInt, true, 2},
+		{"finalized", KindBool, false, 2},
+	}},
+	{Type: "pollvote", Since: 2, record: reflect.TypeOf(PollVoteRecord{}), Fields: []Field{
+		{"userid", KindString, false, 2},
+		{"messageid", KindString, true, 2},
+		{"answerid", KindInt, true, 2},
+		{"count", KindInt, true, 2},
+	}},
+	{Type: "pin", Since: 1, record: reflect.TypeOf(PinRecord{}), Fields: []Field{
+		{"messageid", KindString, true, 1},
+	}},
+	{Type: "guild", Since: 1, record: reflect.TypeOf(GuildRecord{}), Fields: []Field{
+		{"id", KindString, true, 1},
+		{"name", KindString, true, 1},
+		{"icon", KindString, false, 1},
+		{"splash", KindString, false, 1},
+		{"ownerid", KindString, true, 1},
+		{"afkchanid", KindString, false, 1},
+		{"afktimeout", KindInt, false, 1},
+		{"embeddable", KindBool, false, 1},
+		{"embedchanid", KindString, false, 1},
+		{"banner", KindString, false, 4},
+		{"discoverysplash", KindString, false, 4},
+	}},
+	{Type: "member", Since: 1, record: reflect.TypeOf(MemberRecord{}), Fields: []Field{
+		{"userid", KindString, true, 1},
+		{"username", KindString, true, 1},
+		{"discriminator", KindString, false, 1},
+		{"avatar", KindString, false, 1},
+		{"nick", KindString, false, 1},
+		{"roles", KindList, false, 1},
+		{"guildavatar", KindString, false, 4},
+		{"banner", KindString, false, 4},
+		{"guildbanner", KindString, false, 4},
+	}},
+	{Type: "ban", Since: 1, record: reflect.TypeOf(BanRecord{}), Fields: []Field{
+		{"userid", KindString, true, 1},
+		{"reason", KindString, false, 1},
+	}},
+	{Type: "role", Since: 1, record: reflect.TypeOf(RoleRecord{}), Fields: []Field{
+		{"id", KindString, true, 1},
+		{"name", KindString, true, 1},
+		{"color", KindInt, true, 1},
+		{"pos", KindInt, true, 1},
+		{"perms", KindInt, true, 1},
+		{"hoist", KindBool, false, 1},
+		{"icon", KindString, false, 4},
+		{"unicodeemoji", KindString, false, 4},
+	}},
+	{Type: "channel", Since: 1, record: reflect.TypeOf(ChannelRecord{}), Fields: []Field{
+		{"id", KindString, true, 1},
+		{"chantype", KindString, true, 1},
+		{"pos", KindInt, true, 1},
+		{"name", KindString, false, 1},
+		{"topic", KindString, false, 1},
+		{"nsfw", KindBool, false, 1},
+		{"category", KindString, false, 1},
+		{"recipients", KindList, false, 1},
+		{"icon", KindString, false, 1},
+	}},
+	{Type: "permoverwrite", Since: 1, record: reflect.TypeOf(PermOverwriteRecord{}), Fields: []Field{
+		{"id", KindString, true, 1},
+		{"overwritetype", KindString, true, 1},
+		{"allow", KindInt, true, 1},
+		{"deny", KindInt, true, 1},
+	}},
+	{Type: "emoji", Since: 1, record: reflect.TypeOf(EmojiRecord{}), Fields: []Field{
+		{"id", KindString, true, 1},
+		{"name", KindString, true, 1},
+		{"nocolons", KindBool, false, 1},
+	}},
+}
+
+var (
+	schemas     = make(map[string]*Schema)
+	recordTypes = make(map[reflect.Type]*Schema)
+	commonIndex []int
+)
+
+// fieldIndex maps the fields to the struct fields of t tagged with their
+// names, it panics if the schema doesn't match the struct.
+func fieldIndex(t reflect.Type, fields []Field) []int {
+	index := make([]int, len(fields))
+	for i, f := range fields {
+		sf, ok := structField(t, f.Name)
+		if !ok {
+			panic(fmt.Sprintf("logentry: %v has no field for %s", t, f.Name))
+		}
+		if !kindMatches(f.Kind, sf.Type) {
+			panic(fmt.Sprintf("logentry: %v.%s can't hold a %v field", t, sf.Name, f.Kind))
+		}
+		index[i] = sf.Index[0]
+	}
+	return index
+}
+
+func structField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		if sf := t.Field(i); sf.Tag.Get("log") == name {
+			return sf, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func kindMatches(k Kind, t reflect.Type) bool {
+	switch k {
+	case KindString:
+		return t.Kind() == reflect.String
+	case KindBool:
+		return t.Kind() == reflect.Bool
+	case KindInt:
+		return t.Kind() == reflect.Int || t.Kind() == reflect.Int64
+	case KindTime:
+		return t == reflect.TypeOf(time.Time{})
+	case KindList:
+		return t == reflect.TypeOf([]string{})
+	case KindJSON:
+		return t == reflect.TypeOf(discordgo.MessageEmbed{})
+	}
+	return false
+}
+
+func init() {
+	commonIndex = fieldIndex(reflect.TypeOf(Record{}), Common)
+	for _, s := range Schemas {
+		if s.record.Field(0).Type != reflect.TypeOf(Record{}) {
+			panic("logentry: " + s.record.String() + " doesn't start with Record")
+		}
+		s.index = fieldIndex(s.record, s.Fields)
+		schemas[s.Type] = s
+		recordTypes[s.record] = s
+	}
+}
+
+// Lookup returns the schema of a record type, or nil if it's unknown.
+func Lookup(typ string) *Schema {
+	return schemas[typ]
+}
+
+// Header returns the names of all fields of a record type, including the
+// common ones, separated with commas.
+func (s *Schema) Header() string {
+	names := make([]string, 0, len(Common)+len(s.Fields))
+	for _, f := range Common {
+		names = append(names, f.Name)
+	}
+	for _, f := range s.Fields {
+		names = append(names, f.Name)
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,398 @@
+// Package logfile reads logs which may have been rotated into compressed
+// segments. Older records of <name> are stored in <name>.1.gz, <name>.2.gz
+// and so on, from the oldest; the uncompressed file holds the newest ones.
+package logfile
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tsudoko/pullcord/storage"
+	"github.com/tsudoko/pullcord/tsv"
+)
+
+const segmentSuffix = ".gz"
+
+// segment returns the number of a segment of name, or 0 if seg isn't one.
+func segment(name, seg string) int {
+	if !strings.HasPrefix(seg, name+".") || !strings.HasSuffix(seg, segmentSuffix) {
+		return 0
+	}
+
+	n, err := strconv.Atoi(seg[len(name)+1 : len(seg)-len(segmentSuffix)])
+	if err != nil || n < 1 {
+		return 0
+	}
+	return n
+}
+
+// Segments returns the names of compressed segments of a log, from the
+// oldest.
+func Segments(st storage.Storage, name string) ([]string, error) {
+	names, err := st.List(path.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+
+	var segs []string
+	for _, n := range names {
+		if segment(name, n) != 0 {
+			segs = append(segs, n)
+		}
+	}
+
+	sort.Slice(segs, func(i, j int) bool { return segment(name, segs[i]) < segment(name, segs[j]) })
+	return segs, nil
+}
+
+type reader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *reader) Close() error {
+	var err error
+	for _, c := range r.closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Parts returns the names of all segments of a log, followed by the log
+// itself if it exists.
+func Parts(st storage.Storage, name string) ([]string, error) {
+	parts, err := Segments(st, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := st.Stat(name); err == nil {
+		parts = append(parts, name)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return parts, nil
+}
+
+// OpenPart returns the uncompressed contents of a segment or a log without
+// its segments.
+func OpenPart(st storage.Storage, part string) (io.ReadCloser, error) {
+	if strings.HasSuffix(part, segmentSuffix) {
+		r, _, err := openSegment(st, part)
+		return r, err
+	}
+
+	segs, err := Segments(st, part)
+	if err != nil {
+		return nil, err
+	}
+	var comment string
+	if len(segs) > 0 {
+		if comment, err = segmentComment(st, segs[len(segs)-1]); err != nil {
+			return nil, err
+		}
+	}
+	return openLog(st, part, comment)
+}
+
+// openPart opens the parts of a log in order, last holds the comment of the
+// last segment opened so far.
+func openPart(st storage.Storage, part string, last *string) (io.ReadCloser, error) {
+	if !strings.HasSuffix(part, segmentSuffix) {
+		return openLog(st, part, *last)
+	}
+
+	r, comment, err := openSegment(st, part)
+	if err != nil {
+		return nil, err
+	}
+	*last = comment
+	return r, nil
+}
+
+func openSegment(st storage.Storage, part string) (io.ReadCloser, string, error) {
+	f, err := st.Open(part)
+	if err != nil {
+		return nil, "", err
+	}
+
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, "", err
+	}
+	return &reader{z, []io.Closer{z, f}}, z.Header.Comment, nil
+}
+
+func segmentComment(st storage.Storage, part string) (string, error) {
+	r, comment, err := openSegment(st, part)
+	if err != nil {
+		return "", err
+	}
+	r.Close()
+	return comment, nil
+}
+
+// openLog opens a log without its segments, skipping the records which are
+// already in the last segment, with the given comment.
+func openLog(st storage.Storage, name, comment string) (io.ReadCloser, error) {
+	skip, err := rotated(st, name, comment)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := st.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, f, skip); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// The gzip comment of a segment holds the size and the SHA-256 of the log it
+// was made from. If a rotation is interrupted before the log is emptied, the
+// log still begins with them, and readers skip that part.
+func rotationComment(size int64, sum []byte) string {
+	return fmt.Sprintf("%d %x", size, sum)
+}
+
+// rotated returns the number of bytes at the beginning of a log which are
+// already in the segment with the given comment.
+func rotated(st storage.Storage, name, comment string) (int64, error) {
+	var size int64
+	var sum string
+	if _, err := fmt.Sscanf(comment, "%d %s", &size, &sum); err != nil || size <= 0 {
+		return 0, nil
+	}
+
+	n, err := st.Stat(name)
+	if err != nil || n < size {
+		return 0, err
+	}
+
+	f, err := st.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, size); err != nil {
+		return 0, err
+	}
+	if rotationComment(size, h.Sum(nil)) != comment {
+		return 0, nil
+	}
+	return size, nil
+}
+
+// PutPart replaces a segment or a log without its segments with the contents
+// of r, compressing it if needed.
+func PutPart(st storage.Storage, part string, r io.Reader) error {
+	var comment string
+	if strings.HasSuffix(part, segmentSuffix) {
+		var err error
+		comment, err = segmentComment(st, part)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return putPart(st, part, r, comment)
+}
+
+func putPart(st storage.Storage, part string, r io.Reader, comment string) error {
+	tmp, err := os.CreateTemp("", "pullcord-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if strings.HasSuffix(part, segmentSuffix) {
+		z := gzip.NewWriter(tmp)
+		z.Comment = comment
+		if _, err := io.Copy(z, r); err != nil {
+			return err
+		}
+		err = z.Close()
+	} else {
+		_, err = io.Copy(tmp, r)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return st.Put(part, tmp)
+}
+
+// Open returns the contents of a log and all its segments, in order. The
+// error satisfies os.IsNotExist if there's neither.
+func Open(st storage.Storage, name string) (io.ReadCloser, error) {
+	parts, err := Parts(st, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	r := new(reader)
+	var readers []io.Reader
+	var last string
+	for _, part := range parts {
+		f, err := openPart(st, part, &last)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.closers = append(r.closers, f)
+		readers = append(readers, f)
+	}
+
+	r.Reader = io.MultiReader(readers...)
+	return r, nil
+}
+
+// Decode calls f for every record of a log and its segments, in order.
+// Errors, including the ones returned by f, are *tsv.ParseError with the
+// segment and the line of the record.
+func Decode(st storage.Storage, name string, f func(record []string) error) error {
+	parts, err := Parts(st, name)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	var last string
+	for _, part := range parts {
+		if err := decodePart(st, part, &last, f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func decodePart(st storage.Storage, part string, last *string, f func(record []string) error) error {
+	r, err := openPart(st, part, last)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	dec := tsv.NewDecoder(r)
+	for {
+		record, err := dec.Decode()
+		if err == io.EOF {
+			return nil
+		}
+		if err == nil {
+			err = f(record)
+		}
+
+		if perr, ok := err.(*tsv.ParseError); ok {
+			perr.File = part
+			return perr
+		} else if err != nil {
+			return &tsv.ParseError{File: part, Line: dec.Line(), Err: err}
+		}
+	}
+}
+
+// Rotate compresses a log into a new segment and empties it if it's larger
+// than max bytes. A rotation interrupted before the log was emptied is
+// finished first.
+func Rotate(st storage.Storage, name string, max int64) error {
+	size, err := st.Stat(name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if size <= max {
+		return nil
+	}
+
+	segs, err := Segments(st, name)
+	if err != nil {
+		return err
+	}
+	n := 1
+	if len(segs) > 0 {
+		last := segs[len(segs)-1]
+		n = segment(name, last) + 1
+
+		comment, err := segmentComment(st, last)
+		if err != nil {
+			return err
+		}
+		skip, err := rotated(st, name, comment)
+		if err != nil {
+			return err
+		}
+		if skip > 0 {
+			if err := truncate(st, name, skip); err != nil {
+				return err
+			}
+			if size -= skip; size <= max {
+				return nil
+			}
+		}
+	}
+
+	f, err := st.Open(name)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = io.CopyN(h, f, size)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	f, err = st.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	seg := name + "." + strconv.Itoa(n) + segmentSuffix
+	if err := putPart(st, seg, io.LimitReader(f, size), rotationComment(size, h.Sum(nil))); err != nil {
+		return err
+	}
+
+	return truncate(st, name, size)
+}
+
+// truncate removes the first n bytes of a log.
+func truncate(st storage.Storage, name string, n int64) error {
+	f, err := st.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.CopyN(io.Discard, f, n); err != nil {
+		return err
+	}
+	return putPart(st, name, f, "")
+}
@@ -0,0 +1,238 @@
+package logfile
+
+import (
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tsudoko/pullcord/storage"
+	"github.com/tsudoko/pullcord/tsv"
+)
+
+const testLog = "channels/1/2.tsv"
+
+func appendLines(t *testing.T, st storage.Storage, lines ...string) {
+	t.Helper()
+	w, err := st.Append(testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range lines {
+		io.WriteString(w, l+"\n")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func decodeAll(t *testing.T, st storage.Storage) []string {
+	t.Helper()
+	var got []string
+	err := Decode(st, testLog, func(record []string) error {
+		got = append(got, strings.Join(record, " "))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func readAll(t *testing.T, st storage.Storage) string {
+	t.Helper()
+	r, err := Open(st, testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestSegments(t *testing.T) {
+	st := storage.Dir(t.TempDir())
+	for _, name := range []string{
+		testLog,
+		testLog + ".10.gz",
+		testLog + ".2.gz",
+		testLog + ".1.gz",
+		testLog + ".0.gz",
+		testLog + ".x.gz",
+		testLog + ".3",
+		"channels/1/2.tsv2.4.gz",
+		"channels/1/20.tsv.5.gz",
+	} {
+		if err := st.Put(name, strings.NewReader("")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	segs, err := Segments(st, testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{testLog + ".1.gz", testLog + ".2.gz", testLog + ".10.gz"}
+	if !reflect.DeepEqual(segs, want) {
+		t.Errorf("got %q, want %q", segs, want)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	st := storage.Dir(t.TempDir())
+
+	// missing logs aren't rotated
+	if err := Rotate(st, testLog, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := Decode(st, testLog, nil); !os.IsNotExist(err) {
+		t.Errorf("missing log: got %v", err)
+	}
+
+	appendLines(t, st, "a\t1", "b\t2")
+	if err := Rotate(st, testLog, 8); err != nil {
+		t.Fatal(err)
+	}
+	if segs, _ := Segments(st, testLog); len(segs) != 0 {
+		t.Errorf("rotated a small log into %q", segs)
+	}
+
+	if err := Rotate(st, testLog, 4); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, st, "c\t3")
+	if err := Rotate(st, testLog, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, st, "d\t4")
+
+	segs, err := Segments(st, testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{testLog + ".1.gz", testLog + ".2.gz"}; !reflect.DeepEqual(segs, want) {
+		t.Errorf("segments: got %q, want %q", segs, want)
+	}
+	if size, err := st.Stat(testLog); err != nil || size != 4 {
+		t.Errorf("log size: got %v, %v", size, err)
+	}
+
+	want := []string{"a 1", "b 2", "c 3", "d 4"}
+	if got := decodeAll(t, st); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := readAll(t, st), "a\t1\nb\t2\nc\t3\nd\t4\n"; got != want {
+		t.Errorf("Open: got %q, want %q", got, want)
+	}
+}
+
+func TestRotateInterrupted(t *testing.T) {
+	st := storage.Dir(t.TempDir())
+
+	appendLines(t, st, "a\t1", "b\t2")
+	if err := Rotate(st, testLog, 0); err != nil {
+		t.Fatal(err)
+	}
+	// as if the log wasn't emptied, then appended to
+	if err := st.Put(testLog, strings.NewReader("a\t1\nb\t2\n")); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, st, "c\t3")
+
+	want := []string{"a 1", "b 2", "c 3"}
+	if got := decodeAll(t, st); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := readAll(t, st), "a\t1\nb\t2\nc\t3\n"; got != want {
+		t.Errorf("Open: got %q, want %q", got, want)
+	}
+	r, err := OpenPart(st, testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(r)
+	r.Close()
+	if string(b) != "c\t3\n" {
+		t.Errorf("OpenPart: got %q", b)
+	}
+
+	// the next rotation finishes the interrupted one
+	if err := Rotate(st, testLog, 4); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := st.Stat(testLog); err != nil || size != 4 {
+		t.Errorf("log size: got %v, %v", size, err)
+	}
+	if segs, _ := Segments(st, testLog); len(segs) != 1 {
+		t.Errorf("segments: got %q", segs)
+	}
+	if got := decodeAll(t, st); !reflect.DeepEqual(got, want) {
+		t.Errorf("after rotating: got %q, want %q", got, want)
+	}
+
+	// a log which only looks like the segment is read in full
+	if err := st.Put(testLog, strings.NewReader("a\t1\nb\t3\n")); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"a 1", "b 2", "a 1", "b 3"}
+	if got := decodeAll(t, st); !reflect.DeepEqual(got, want) {
+		t.Errorf("different log: got %q, want %q", got, want)
+	}
+}
+
+func TestPutPartKeepsRotation(t *testing.T) {
+	st := storage.Dir(t.TempDir())
+
+	appendLines(t, st, "a\t1")
+	if err := Rotate(st, testLog, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := PutPart(st, testLog+".1.gz", strings.NewReader("a\tx\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Put(testLog, strings.NewReader("a\t1\nb\t2\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"a x", "b 2"}
+	if got := decodeAll(t, st); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	st := storage.Dir(t.TempDir())
+
+	appendLines(t, st, "a\t1", "b\t2")
+	if err := Rotate(st, testLog, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, st, "c\t3", "d\t4")
+
+	tests := []struct {
+		stop string
+		file string
+		line int
+	}{
+		{"b", testLog + ".1.gz", 2},
+		{"d", testLog, 2},
+	}
+	for _, tt := range tests {
+		stop := errors.New("stop")
+		err := Decode(st, testLog, func(record []string) error {
+			if record[0] == tt.stop {
+				return stop
+			}
+			return nil
+		})
+		var perr *tsv.ParseError
+		if !errors.As(err, &perr) || perr.File != tt.file || perr.Line != tt.line || perr.Err != stop {
+			t.Errorf("stopping at %s: got %v, want an error at %s:%d", tt.stop, err, tt.file, tt.line)
+		}
+	}
+}
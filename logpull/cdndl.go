@@ -6,6 +6,7 @@ import (
 	"github.com/bwmarrin/discordgo"
 
 	"github.com/tsudoko/pullcord/cdndl"
+	"github.com/tsudoko/pullcord/logentry"
 )
 
 const (
@@ -14,60 +15,169 @@ const (
 	cdnSplash
 	cdnAvatar
 	cdnChannelIcon
+	cdnBanner
+	cdnDiscoverySplash
+	cdnMemberAvatar
+	cdnMemberBanner
 )
 
 var skipCodes = map[int]bool{404: true, 403: true}
 
-// TODO: move somewhere else
-func handleDLError(err error) error {
+// handleDLError skips files which are gone and records other failures of
+// downloading URL, so they don't stop the pull. Transient errors are retried
+// and recorded by cdndl, only errors from recording them are left.
+func handleDLError(dl *cdndl.Manager, URL string, err error) error {
+	if err == nil {
+		return nil
+	}
+
 	if cerr, ok := err.(cdndl.ErrNotOk); ok && skipCodes[cerr.StatusCode] {
 		log.Printf("warning: skipping %s (%d)", cerr.URL, cerr.StatusCode)
 		return nil
-	} else {
-		// TODO: retry?
-		return err
 	}
+
+	log.Printf("warning: downloading %s failed: %v", URL, err)
+	return dl.RecordFailed(URL, err)
 }
 
-func (p *Puller) cdnDL(v interface{}, subtype int) error {
-	if p.lightMode {
-		return nil
+func (p *Puller) cdnDL(v interface{}, subtype int, what string) {
+	if p.LightMode {
+		return
+	}
+
+	fetch(p.dl, what, p.policyItem(v, subtype), func() error { return p.cdnDLSync(v, subtype) })
+}
+
+// policyItem describes a file for the download policy.
+func (p *Puller) policyItem(v interface{}, subtype int) *cdndl.Item {
+	it := policyItem("", cdnURL(v, subtype), p.gid, p.channel)
+
+	switch v := v.(type) {
+	case *discordgo.MessageAttachment:
+		it.Kind = cdndl.KindAttachment
+		it.Size = int64(v.Size)
+		it.ContentType = v.ContentType
+	case *logentry.EmbedMedia:
+		it.Kind = cdndl.KindEmbed
+	case *discordgo.User, *discordgo.Member:
+		it.Kind = cdndl.KindAvatar
+	case *discordgo.Emoji:
+		it.Kind = cdndl.KindEmoji
+	case *discordgo.Guild, *discordgo.Channel, *discordgo.Role:
+		it.Kind = cdndl.KindIcon
+	}
+
+	return it
+}
+
+// cdnURL returns the URL cdnDLSync downloads v from.
+func cdnURL(v interface{}, subtype int) string {
+	switch v := v.(type) {
+	case *discordgo.MessageAttachment:
+		return v.URL
+	case *discordgo.Guild:
+		switch subtype {
+		case cdnIcon:
+			return cdndl.IconURL(v.ID, v.Icon)
+		case cdnSplash:
+			return cdndl.SplashURL(v.ID, v.Splash)
+		case cdnBanner:
+			return cdndl.BannerURL(v.ID, v.Banner)
+		case cdnDiscoverySplash:
+			return cdndl.DiscoverySplashURL(v.ID, v.DiscoverySplash)
+		}
+	case *discordgo.User:
+		switch subtype {
+		case cdnAvatar:
+			return cdndl.AvatarURL(v.ID, v.Avatar)
+		case cdnBanner:
+			return cdndl.UserBannerURL(v.ID, v.Banner)
+		}
+	case *discordgo.Member:
+		switch subtype {
+		case cdnMemberAvatar:
+			return cdndl.MemberAvatarURL(v.GuildID, v.User.ID, v.Avatar)
+		case cdnMemberBanner:
+			return cdndl.MemberBannerURL(v.GuildID, v.User.ID, v.Banner)
+		}
+	case *discordgo.Role:
+		return cdndl.RoleIconURL(v.ID, v.Icon)
+	case *discordgo.Channel:
+		return cdndl.ChannelIconURL(v.ID, v.Icon)
+	case *discordgo.Emoji:
+		return cdndl.EmojiURL(v.ID, v.Animated)
+	case *logentry.EmbedMedia:
+		if v.ProxyURL != "" {
+			return v.ProxyURL
+		}
+		return v.URL
 	}
+	return ""
+}
 
+func (p *Puller) cdnDLSync(v interface{}, subtype int) error {
 	var err error
 
 	switch v.(type) {
 	case *discordgo.MessageAttachment:
-		err = cdndl.Attachment(v.(*discordgo.MessageAttachment).URL)
+		err = p.dl.Attachment(v.(*discordgo.MessageAttachment).URL)
 	case *discordgo.Guild:
 		g := v.(*discordgo.Guild)
 		switch subtype {
 		case cdnIcon:
-			err = cdndl.Icon(g.ID, g.Icon)
+			err = p.dl.Icon(g.ID, g.Icon)
 		case cdnSplash:
-			err = cdndl.Splash(g.ID, g.Splash)
+			err = p.dl.Splash(g.ID, g.Splash)
+		case cdnBanner:
+			err = p.dl.Banner(g.ID, g.Banner)
+		case cdnDiscoverySplash:
+			err = p.dl.DiscoverySplash(g.ID, g.DiscoverySplash)
 		default:
 			panic("unsupported subtype")
 		}
 	case *discordgo.User:
-		if subtype == cdnAvatar {
-			err = cdndl.Avatar(v.(*discordgo.User))
-		} else {
+		u := v.(*discordgo.User)
+		switch subtype {
+		case cdnAvatar:
+			err = p.dl.Avatar(u)
+		case cdnBanner:
+			err = p.dl.UserBanner(u.ID, u.Banner)
+		default:
+			panic("unsupported subtype")
+		}
+	case *discordgo.Member:
+		m := v.(*discordgo.Member)
+		switch subtype {
+		case cdnMemberAvatar:
+			err = p.dl.MemberAvatar(m.GuildID, m.User.ID, m.Avatar)
+		case cdnMemberBanner:
+			err = p.dl.MemberBanner(m.GuildID, m.User.ID, m.Banner)
+		default:
 			panic("unsupported subtype")
 		}
+	case *discordgo.Role:
+		r := v.(*discordgo.Role)
+		err = p.dl.RoleIcon(r.ID, r.Icon)
 	case *discordgo.Channel:
 		c := v.(*discordgo.Channel)
 		if subtype == cdnChannelIcon {
-			err = cdndl.ChannelIcon(c.ID, c.Icon)
+			err = p.dl.ChannelIcon(c.ID, c.Icon)
 		} else {
 			panic("unsupported subtype")
 		}
 	case *discordgo.Emoji:
 		e := v.(*discordgo.Emoji)
-		err = cdndl.Emoji(e.ID, e.Animated)
+		err = p.dl.Emoji(e.ID, e.Animated)
+	case *logentry.EmbedMedia:
+		m := v.(*logentry.EmbedMedia)
+		if m.ProxyURL != "" {
+			err = p.dl.EmbedMedia(m.ProxyURL)
+		} else {
+			err = p.dl.EmbedMedia(m.URL)
+		}
 	default:
 		panic("unsupported type")
 	}
 
-	return handleDLError(err)
+	return err
 }
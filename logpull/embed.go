@@ -0,0 +1,66 @@
+package logpull
+
+import (
+	"io"
+	"log"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/tsudoko/pullcord/cdndl"
+	"github.com/tsudoko/pullcord/logentry"
+	"github.com/tsudoko/pullcord/tsv"
+)
+
+func embedMedia(mid string, e *discordgo.MessageEmbed) []*logentry.EmbedMedia {
+	var media []*logentry.EmbedMedia
+	add := func(kind, URL, proxyURL string) {
+		if URL != "" || proxyURL != "" {
+			media = append(media, &logentry.EmbedMedia{MessageID: mid, Kind: kind, URL: URL, ProxyURL: proxyURL})
+		}
+	}
+
+	if e.Thumbnail != nil {
+		add("thumbnail", e.Thumbnail.URL, e.Thumbnail.ProxyURL)
+	}
+	if e.Image != nil {
+		add("image", e.Image.URL, e.Image.ProxyURL)
+	}
+	if e.Author != nil {
+		add("authoricon", e.Author.IconURL, e.Author.ProxyIconURL)
+	}
+	if e.Footer != nil {
+		add("footericon", e.Footer.IconURL, e.Footer.ProxyIconURL)
+	}
+	// videos from third-party providers are usually web players, not files
+	if e.Video != nil && (e.Provider == nil || e.Type == discordgo.EmbedTypeGifv) {
+		add("video", e.Video.URL, "")
+	}
+
+	return media
+}
+
+// pullEmbedMedia downloads files referenced by an embed and records where
+// they're stored, proxy URLs are preferred since they don't expire as often.
+func (p *Puller) pullEmbedMedia(w io.Writer, mid string, e *discordgo.MessageEmbed) {
+	for _, m := range embedMedia(mid, e) {
+		URL := m.ProxyURL
+		if URL == "" {
+			URL = m.URL
+		}
+
+		fPath, err := cdndl.LocalPath(URL)
+		if err != nil {
+			log.Printf("warning: skipping %s of an embed in %s: %v", m.Kind, mid, err)
+			continue
+		}
+		m.Path = fPath
+
+		p.cdnDL(m, 0, "downloading "+m.Kind+" of an embed in "+mid)
+
+		// signatures of Discord-hosted files expire, they're refreshed when needed
+		stripped := *m
+		stripped.URL = cdndl.StripSignature(m.URL)
+		stripped.ProxyURL = cdndl.StripSignature(m.ProxyURL)
+		tsv.Write(w, logentry.Make("history", "add", &stripped))
+	}
+}
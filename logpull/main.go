@@ -4,22 +4,26 @@ package logpull
 import (
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path"
-	"regexp"
 
 	"github.com/bwmarrin/discordgo"
 
+	"github.com/tsudoko/pullcord/cdndl"
 	"github.com/tsudoko/pullcord/logcache"
 	"github.com/tsudoko/pullcord/logentry"
+	"github.com/tsudoko/pullcord/logfile"
 	"github.com/tsudoko/pullcord/logutil"
+	"github.com/tsudoko/pullcord/retry"
+	"github.com/tsudoko/pullcord/storage"
 	"github.com/tsudoko/pullcord/tsv"
 )
 
 type PullError struct {
 	what string
-	err error
+	err  error
 }
 
 func (e *PullError) Error() string {
@@ -27,20 +31,29 @@ func (e *PullError) Error() string {
 }
 
 type Puller struct {
-	d *discordgo.Session
+	Retry     retry.Policy // for REST API requests
+	LightMode bool         // if true, attachments, emoji, icons, etc. aren't downloaded, see FetchMissing
+	Embeds    bool         // if true, images and videos referenced by embeds are downloaded
 
-	log *os.File
+	RotateSize int64 // if non-zero, channel logs larger than this are compressed into segments, see logfile
 
-	// not fully implemented yet, we currently don't check if all emoji/attachments/etc with log entries have been downloaded
-	lightMode bool // if true, attachments, emoji, icons, etc. aren't downloaded
+	d       *discordgo.Session
+	dl      *cdndl.Manager
+	st      storage.Storage
+	gid     string
+	channel string // ID of the channel being pulled, for download policies
+
+	log     io.WriteCloser
+	logName string
 
 	cache   logcache.Entries // for tracking changes between different pulls
 	ever    logcache.IDs     // for determining if there's a need to add an entry for an external entity, i.e. a user who left
 	deleted logcache.IDs     // for tracking deletions between different pulls, cache could be used for that as well
 }
 
-func NewPuller(d *discordgo.Session, gid string) (*Puller, error) {
-	p := &Puller{d: d}
+// NewPuller returns a puller which writes logs of the guild gid to st.
+func NewPuller(d *discordgo.Session, dl *cdndl.Manager, st storage.Storage, gid string) (*Puller, error) {
+	p := &Puller{Retry: retry.Default, d: d, dl: dl, st: st, gid: gid}
 
 	if err := p.openLog(gid); err != nil {
 		return nil, &PullError{"opening the log file", err}
@@ -62,15 +75,24 @@ func (p *Puller) openLog(id string) error {
 		return nil
 	}
 
-	filename := fmt.Sprintf("channels/%s/guild.tsv", id)
-	if err := os.MkdirAll(path.Dir(filename), os.ModeDir|0755); err != nil {
-		return errors.New("creating the guild dir failed")
+	name := path.Join("channels", id, "guild.tsv")
+	f, err := p.st.Append(name)
+	if err != nil {
+		return err
 	}
 
-	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-	p.log = f
+	p.log, p.logName = f, name
+	return nil
+}
 
-	return err
+// sync flushes the guild log and the given channel logs to disk if they're
+// local files.
+func (p *Puller) sync(logs ...io.Writer) {
+	for _, w := range append(logs, p.log) {
+		if f, ok := w.(*os.File); ok {
+			f.Sync()
+		}
+	}
 }
 
 func (p *Puller) loadCaches() error {
@@ -85,11 +107,11 @@ func (p *Puller) loadCaches() error {
 	p.cache = make(logcache.Entries)
 	p.ever = make(logcache.IDs)
 
-	if err := logcache.NewEntries(p.log.Name(), &p.cache); err != nil {
+	if err := logcache.NewEntries(p.st, p.logName, &p.cache); err != nil {
 		return err
 	}
 
-	if err := logutil.AllIDs(p.log.Name(), &p.ever); err != nil {
+	if err := logutil.AllIDs(p.st, p.logName, &p.ever); err != nil {
 		return err
 	}
 
@@ -105,17 +127,19 @@ func (p *Puller) PullGuild(id string) error {
 	}
 
 	if guild.Icon != "" {
-		err := p.cdnDL(guild, cdnIcon)
-		if err != nil {
-			return &PullError{"downloading the guild icon", err}
-		}
+		p.cdnDL(guild, cdnIcon, "downloading the guild icon")
 	}
 
 	if guild.Splash != "" {
-		err := p.cdnDL(guild, cdnSplash)
-		if err != nil {
-			return &PullError{"downloading the guild splash", err}
-		}
+		p.cdnDL(guild, cdnSplash, "downloading the guild splash")
+	}
+
+	if guild.Banner != "" {
+		p.cdnDL(guild, cdnBanner, "downloading the guild banner")
+	}
+
+	if guild.DiscoverySplash != "" {
+		p.cdnDL(guild, cdnDiscoverySplash, "downloading the guild discovery splash")
 	}
 
 	p.cache.WriteNew(p.log, logentry.Make("history", "add", guild))
@@ -141,15 +165,15 @@ func (p *Puller) PullGuild(id string) error {
 	}
 
 	for _, r := range guild.Roles {
+		if r.Icon != "" {
+			p.cdnDL(r, 0, "downloading icon for role "+r.ID)
+		}
 		p.cache.WriteNew(p.log, logentry.Make("history", "add", r))
 		delete(p.deleted[logentry.Type(r)], r.ID)
 	}
 
 	for _, e := range guild.Emojis {
-		err := p.cdnDL(e, 0)
-		if err != nil {
-			return &PullError{"downloading emoji " + e.ID, err}
-		}
+		p.cdnDL(e, 0, "downloading emoji "+e.ID)
 		p.cache.WriteNew(p.log, logentry.Make("history", "add", e))
 		delete(p.deleted[logentry.Type(e)], e.ID)
 	}
@@ -162,12 +186,16 @@ func (p *Puller) PullGuild(id string) error {
 	// between pullcord runs) can be recorded, without nicknames
 	if !isBotSession(p.d) {
 		log.Printf("[%s] cannot download members with a user token, member data will not be fully accurate", id)
-		return nil
+		return p.dl.Wait()
 	}
 
 	after := "0"
 	for {
-		members, err := p.d.GuildMembers(id, after, 1000)
+		var members []*discordgo.Member
+		err := p.Retry.Do("getting members from "+after, func() (err error) {
+			members, err = p.d.GuildMembers(id, after, 1000)
+			return
+		}, temporaryREST)
 		if err != nil {
 			return &PullError{"getting members from " + after, err}
 		}
@@ -178,6 +206,7 @@ func (p *Puller) PullGuild(id string) error {
 
 		for _, m := range members {
 			after = m.User.ID
+			m.GuildID = id
 			if err := p.pullMember(m); err != nil {
 				return err
 			}
@@ -186,7 +215,7 @@ func (p *Puller) PullGuild(id string) error {
 		log.Printf("[%s] downloaded %d members, last id %s with name %s", id, len(members), after, members[len(members)-1].User.Username)
 	}
 
-	p.log.Sync()
+	p.sync()
 
 	for etype, ids := range p.deleted {
 		for id := range ids {
@@ -197,15 +226,24 @@ func (p *Puller) PullGuild(id string) error {
 		}
 	}
 
-	return nil
+	return p.dl.Wait()
 }
 
 func (p *Puller) pullMember(m *discordgo.Member) error {
 	if m.User.Avatar != "" {
-		err := p.cdnDL(m.User, cdnAvatar)
-		if err != nil {
-			return &PullError{"downloading avatar for user " + m.User.ID, err}
-		}
+		p.cdnDL(m.User, cdnAvatar, "downloading avatar for user "+m.User.ID)
+	}
+
+	if m.User.Banner != "" {
+		p.cdnDL(m.User, cdnBanner, "downloading banner for user "+m.User.ID)
+	}
+
+	if m.Avatar != "" {
+		p.cdnDL(m, cdnMemberAvatar, "downloading server avatar for user "+m.User.ID)
+	}
+
+	if m.Banner != "" {
+		p.cdnDL(m, cdnMemberBanner, "downloading server banner for user "+m.User.ID)
 	}
 
 	if p.ever["member"] == nil {
@@ -219,13 +257,15 @@ func (p *Puller) pullMember(m *discordgo.Member) error {
 	return nil
 }
 
-func (p *Puller) PullDMGuild() error {
-	chans, err := p.d.UserChannels()
-	if err != nil {
-		return &PullError{"getting DM channels", err}
-	}
+// DMChannels returns the DM and group DM channels received in the Ready event.
+func DMChannels(d *discordgo.Session) []*discordgo.Channel {
+	d.State.RLock()
+	defer d.State.RUnlock()
+	return append([]*discordgo.Channel(nil), d.State.PrivateChannels...)
+}
 
-	for _, c := range chans {
+func (p *Puller) PullDMGuild() error {
+	for _, c := range DMChannels(p.d) {
 		p.cache.WriteNew(p.log, logentry.Make("history", "add", c))
 		delete(p.deleted[logentry.Type(c)], c.ID)
 		for _, r := range c.Recipients {
@@ -245,7 +285,7 @@ func (p *Puller) PullDMGuild() error {
 		*/
 	}
 
-	p.log.Sync()
+	p.sync()
 
 	for etype, ids := range p.deleted {
 		for id := range ids {
@@ -256,35 +296,54 @@ func (p *Puller) PullDMGuild() error {
 		}
 	}
 
-	return nil
+	return p.dl.Wait()
 }
 
-func (p *Puller) PullChannel(c *discordgo.Channel) error {
+func (p *Puller) PullChannel(c *discordgo.Channel) (err error) {
+	p.channel = c.ID
+	defer func() { p.channel = "" }()
+
 	after := "0"
-	filename := fmt.Sprintf("channels/%s/%s.tsv", c.GuildID, c.ID)
+	filename := path.Join("channels", c.GuildID, c.ID+".tsv")
 
-	if _, err := os.Stat(filename); err == nil {
-		after, err = logutil.LastMessageID(filename)
-		if err != nil {
-			return &PullError{"getting last message id", err}
-		}
+	if id, err := logutil.LastMessageID(p.st, filename); err == nil {
+		after = id
+	} else if !os.IsNotExist(err) {
+		return &PullError{"getting last message id", err}
 	}
 
 	if c.Icon != "" {
-		err := p.cdnDL(c, cdnChannelIcon)
-		if err != nil {
-			return &PullError{"downloading channel icon", err}
-		}
+		p.cdnDL(c, cdnChannelIcon, "downloading channel icon")
 	}
 
-	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	f, err := p.st.Append(filename)
 	if err != nil {
 		return &PullError{"opening the log file", err}
 	}
-	defer f.Close()
+	defer func() {
+		// non-local storages write the log on Close
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = &PullError{"writing the log file", cerr}
+		}
+		if err == nil && p.RotateSize > 0 {
+			if rerr := logfile.Rotate(p.st, filename, p.RotateSize); rerr != nil {
+				err = &PullError{"rotating the log file", rerr}
+			}
+		}
+	}()
+
+	if after != "0" {
+		if err := p.refreshPolls(f, c, filename); err != nil {
+			return err
+		}
+	}
 
 	for {
-		msgs, err := p.d.ChannelMessages(c.ID, 100, "", after, "")
+		var msgs []*discordgo.Message
+		err := p.Retry.Do("getting messages from "+after, func() (err error) {
+			msgs, err = p.d.ChannelMessages(c.ID, 100, "", after, "")
+			return
+		}, temporaryREST)
 		if r, ok := err.(*discordgo.RESTError); ok && r.Message != nil && r.Message.Code == 50001 { // Missing Access
 			log.Printf("[%s/%s] warning: skipping channel (%s)", c.GuildID, c.ID, r.Message.Message)
 			break
@@ -307,10 +366,12 @@ func (p *Puller) PullChannel(c *discordgo.Channel) error {
 			}
 
 			if msgs[i].Author.Avatar != "" {
-				err = p.cdnDL(msgs[i].Author, cdnAvatar)
-				if err != nil {
-					return &PullError{"downloading avatar for user " + msgs[i].Author.ID, err}
-				}
+				p.cdnDL(msgs[i].Author, cdnAvatar, "downloading avatar for user "+msgs[i].Author.ID)
+			}
+
+			// members attached to messages don't include users
+			if m := msgs[i].Member; m != nil && m.Avatar != "" {
+				p.cdnDL(&discordgo.Member{GuildID: c.GuildID, User: msgs[i].Author, Avatar: m.Avatar}, cdnMemberAvatar, "downloading server avatar for user "+msgs[i].Author.ID)
 			}
 
 			var msgMember *discordgo.Member
@@ -340,10 +401,7 @@ func (p *Puller) PullChannel(c *discordgo.Channel) error {
 					member := &discordgo.Member{User: u}
 
 					if member.User.Avatar != "" {
-						err := p.cdnDL(member.User, cdnAvatar)
-						if err != nil {
-							return &PullError{"downloading avatar for user " + member.User.ID, err}
-						}
+						p.cdnDL(member.User, cdnAvatar, "downloading avatar for user "+member.User.ID)
 					}
 
 					p.cache.WriteNew(p.log, logentry.Make("history", "del", member))
@@ -351,35 +409,48 @@ func (p *Puller) PullChannel(c *discordgo.Channel) error {
 				}
 			}
 
-			for _, match := range regexp.MustCompile("<(a?):[^:]+:([0-9]+)>").FindAllStringSubmatch(msgs[i].Content, -1) {
+			for _, match := range emojiRegexp.FindAllStringSubmatch(msgs[i].Content, -1) {
 				e := &discordgo.Emoji{ID: match[2], Animated: match[1] == "a"}
-				err := p.cdnDL(e, 0)
-				if err != nil {
-					return &PullError{"downloading external emoji " + e.ID, err}
-				}
+				p.cdnDL(e, 0, "downloading external emoji "+e.ID)
 			}
 
 			for _, e := range msgs[i].Embeds {
-				tsv.Write(f, logentry.Make("history", "add", &logentry.Embed{*e, msgs[i].ID}))
+				if p.Embeds {
+					p.pullEmbedMedia(f, msgs[i].ID, e)
+				}
+				tsv.Write(f, logentry.Make("history", "add", &logentry.Embed{MessageEmbed: *e, MessageID: msgs[i].ID}))
 			}
 
 			for _, a := range msgs[i].Attachments {
-				err := p.cdnDL(a, 0)
-				if err != nil {
-					return &PullError{"downloading attachment " + a.ID + " for message " + msgs[i].ID, err}
+				p.cdnDL(a, 0, "downloading attachment "+a.ID+" for message "+msgs[i].ID)
+				tsv.Write(f, logentry.Make("history", "add", &logentry.Attachment{MessageAttachment: *a, MessageID: msgs[i].ID}))
+			}
+
+			for _, s := range msgs[i].MessageSnapshots {
+				if s.Message == nil {
+					continue
+				}
+				if err := p.pullSnapshot(f, msgs[i].ID, s.Message); err != nil {
+					return err
+				}
+			}
+
+			if msgs[i].Poll != nil {
+				if err := p.pullPoll(f, c, msgs[i], nil); err != nil {
+					return err
 				}
-				tsv.Write(f, logentry.Make("history", "add", &logentry.Attachment{*a, msgs[i].ID}))
 			}
 
 			for _, r := range msgs[i].Reactions {
 				if r.Emoji.ID != "" {
-					err := p.cdnDL(r.Emoji, 0)
-					if err != nil {
-						return &PullError{"downloading external emoji " + r.Emoji.ID, err}
-					}
+					p.cdnDL(r.Emoji, 0, "downloading external emoji "+r.Emoji.ID)
 				}
 
-				users, err := p.d.MessageReactions(c.ID, msgs[i].ID, r.Emoji.APIName(), 100, "", "")
+				var users []*discordgo.User
+				err := p.Retry.Do("getting users for reaction "+r.Emoji.APIName()+" to "+msgs[i].ID, func() (err error) {
+					users, err = p.d.MessageReactions(c.ID, msgs[i].ID, r.Emoji.APIName(), 100, "", "")
+					return
+				}, temporaryREST)
 				if rerr, ok := err.(*discordgo.RESTError); ok && rerr.Message != nil && rerr.Message.Code == 10014 { // Unknown Emoji
 					log.Printf("[%s/%s] warning: skipping reaction \"%s\" for %s (%s)", c.GuildID, c.ID, r.Emoji.APIName(), msgs[i].ID, rerr.Message.Message)
 				} else if err != nil {
@@ -388,8 +459,8 @@ func (p *Puller) PullChannel(c *discordgo.Channel) error {
 
 				for _, u := range users {
 					reaction := &logentry.Reaction{
-						discordgo.MessageReaction{u.ID, msgs[i].ID, *r.Emoji, c.ID, c.GuildID},
-						1,
+						MessageReaction: discordgo.MessageReaction{UserID: u.ID, MessageID: msgs[i].ID, Emoji: *r.Emoji, ChannelID: c.ID, GuildID: c.GuildID},
+						Count:           1,
 					}
 
 					tsv.Write(f, logentry.Make("history", "add", reaction))
@@ -397,8 +468,8 @@ func (p *Puller) PullChannel(c *discordgo.Channel) error {
 
 				if r.Count > 100 {
 					reaction := &logentry.Reaction{
-						discordgo.MessageReaction{"", msgs[i].ID, *r.Emoji, c.ID, c.GuildID},
-						r.Count - 100,
+						MessageReaction: discordgo.MessageReaction{MessageID: msgs[i].ID, Emoji: *r.Emoji, ChannelID: c.ID, GuildID: c.GuildID},
+						Count:           r.Count - 100,
 					}
 					tsv.Write(f, logentry.Make("history", "add", reaction))
 				}
@@ -410,6 +481,25 @@ func (p *Puller) PullChannel(c *discordgo.Channel) error {
 		log.Printf("[%s/%s] downloaded %d messages, last id %s with content %s", c.GuildID, c.ID, len(msgs), msgs[0].ID, msgs[0].Content)
 	}
 
-	p.log.Sync()
+	p.sync(f)
+	return p.dl.Wait()
+}
+
+// pullSnapshot writes a forwarded message along with its attachments and
+// embeds, the original may not be accessible later on.
+func (p *Puller) pullSnapshot(w io.Writer, mid string, m *discordgo.Message) error {
+	for _, e := range m.Embeds {
+		if p.Embeds {
+			p.pullEmbedMedia(w, mid, e)
+		}
+		tsv.Write(w, logentry.Make("history", "add", &logentry.Embed{MessageEmbed: *e, MessageID: mid, Snapshot: true}))
+	}
+
+	for _, a := range m.Attachments {
+		p.cdnDL(a, 0, "downloading attachment "+a.ID+" for forwarded message "+mid)
+		tsv.Write(w, logentry.Make("history", "add", &logentry.Attachment{MessageAttachment: *a, MessageID: mid, Snapshot: true}))
+	}
+
+	tsv.Write(w, logentry.Make("history", "add", &logentry.Snapshot{Message: *m, MessageID: mid}))
 	return nil
 }
@@ -0,0 +1,212 @@
+package logpull
+
+import (
+	"log"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/tsudoko/pullcord/cdndl"
+	"github.com/tsudoko/pullcord/logentry"
+	"github.com/tsudoko/pullcord/logfile"
+	"github.com/tsudoko/pullcord/storage"
+)
+
+var emojiRegexp = regexp.MustCompile("<(a?):[^:]+:([0-9]+)>")
+
+// parse decodes a record, records of types added by newer versions are
+// skipped and returned as nil without an error.
+func parse(e []string) (interface{}, error) {
+	rec, err := logentry.Parse(e)
+	if _, ok := err.(logentry.UnknownTypeError); ok {
+		return nil, nil
+	}
+	return rec, err
+}
+
+// FetchMissing walks all logs in st and downloads files referenced by them
+// which haven't been downloaded yet, e.g. because the logs were pulled in
+// light mode.
+func FetchMissing(st storage.Storage, dl *cdndl.Manager) error {
+	logs, err := st.List("channels")
+	if err != nil {
+		return err
+	}
+
+	for _, fpath := range logs {
+		if ok, _ := path.Match("channels/*/*.tsv", fpath); !ok {
+			continue
+		}
+
+		log.Printf("checking %s", fpath)
+
+		gid := path.Base(path.Dir(fpath))
+		if path.Base(fpath) == "guild.tsv" {
+			err = fetchMissingGuild(st, dl, fpath, gid)
+		} else {
+			cid := strings.TrimSuffix(path.Base(fpath), ".tsv")
+			err = fetchMissingChannel(st, dl, fpath, gid, cid)
+		}
+
+		if err != nil {
+			return &PullError{"reading " + fpath, err}
+		}
+
+		if err := dl.Wait(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// policyItem describes the file at URL for the download policy.
+func policyItem(kind, URL, gid, cid string) *cdndl.Item {
+	return &cdndl.Item{Kind: kind, GuildID: gid, ChannelID: cid, URL: URL, Size: -1}
+}
+
+// fetch queues a download if the file wasn't downloaded yet and the download
+// policy allows it, errors are returned by the next dl.Wait call.
+func fetch(dl *cdndl.Manager, what string, it *cdndl.Item, f func() error) {
+	dl.Go(func() error {
+		if dl.Downloaded(it.URL) || !dl.Allowed(it) {
+			return nil
+		}
+		if err := handleDLError(dl, it.URL, f()); err != nil {
+			return &PullError{what, err}
+		}
+		return nil
+	})
+}
+
+func fetchMissingGuild(st storage.Storage, dl *cdndl.Manager, fpath, gid string) error {
+	return logfile.Decode(st, fpath, func(e []string) error {
+		rec, err := parse(e)
+		if rec == nil {
+			return err
+		}
+
+		switch r := rec.(type) {
+		case *logentry.GuildRecord:
+			id := r.ID
+			if r.Icon != "" {
+				fetch(dl, "downloading the guild icon", policyItem(cdndl.KindIcon, cdndl.IconURL(id, r.Icon), gid, ""), func() error { return dl.Icon(id, r.Icon) })
+			}
+			if r.Splash != "" {
+				fetch(dl, "downloading the guild splash", policyItem(cdndl.KindIcon, cdndl.SplashURL(id, r.Splash), gid, ""), func() error { return dl.Splash(id, r.Splash) })
+			}
+			if r.Banner != "" {
+				fetch(dl, "downloading the guild banner", policyItem(cdndl.KindIcon, cdndl.BannerURL(id, r.Banner), gid, ""), func() error { return dl.Banner(id, r.Banner) })
+			}
+			if r.DiscoverySplash != "" {
+				fetch(dl, "downloading the guild discovery splash", policyItem(cdndl.KindIcon, cdndl.DiscoverySplashURL(id, r.DiscoverySplash), gid, ""), func() error { return dl.DiscoverySplash(id, r.DiscoverySplash) })
+			}
+		case *logentry.MemberRecord:
+			id := r.UserID
+			if r.Avatar != "" {
+				u := &discordgo.User{ID: id, Avatar: r.Avatar}
+				fetch(dl, "downloading avatar for user "+id, policyItem(cdndl.KindAvatar, cdndl.AvatarURL(id, r.Avatar), gid, ""), func() error { return dl.Avatar(u) })
+			}
+			if r.GuildAvatar != "" {
+				fetch(dl, "downloading server avatar for user "+id, policyItem(cdndl.KindAvatar, cdndl.MemberAvatarURL(gid, id, r.GuildAvatar), gid, ""), func() error { return dl.MemberAvatar(gid, id, r.GuildAvatar) })
+			}
+			if r.Banner != "" {
+				fetch(dl, "downloading banner for user "+id, policyItem(cdndl.KindAvatar, cdndl.UserBannerURL(id, r.Banner), gid, ""), func() error { return dl.UserBanner(id, r.Banner) })
+			}
+			if r.GuildBanner != "" {
+				fetch(dl, "downloading server banner for user "+id, policyItem(cdndl.KindAvatar, cdndl.MemberBannerURL(gid, id, r.GuildBanner), gid, ""), func() error { return dl.MemberBanner(gid, id, r.GuildBanner) })
+			}
+		case *logentry.RoleRecord:
+			if r.Icon != "" {
+				fetch(dl, "downloading icon for role "+r.ID, policyItem(cdndl.KindIcon, cdndl.RoleIconURL(r.ID, r.Icon), gid, ""), func() error { return dl.RoleIcon(r.ID, r.Icon) })
+			}
+		case *logentry.ChannelRecord:
+			if r.Icon != "" {
+				fetch(dl, "downloading channel icon", policyItem(cdndl.KindIcon, cdndl.ChannelIconURL(r.ID, r.Icon), gid, ""), func() error { return dl.ChannelIcon(r.ID, r.Icon) })
+			}
+		case *logentry.EmojiRecord:
+			fetch(dl, "downloading emoji "+r.ID, policyItem(cdndl.KindEmoji, cdndl.EmojiURL(r.ID, false), gid, ""), func() error { return dl.EmojiGuess(r.ID) })
+		}
+		return nil
+	})
+}
+
+func fetchMissingChannel(st storage.Storage, dl *cdndl.Manager, fpath, gid, cid string) error {
+	// attachments of forwarded messages are stored under the channel of
+	// the original message, which is only known after the forwarding
+	// message's entry is read
+	refchans := make(map[string]string)
+	var snapshotAttachments []*logentry.AttachmentRecord
+
+	err := logfile.Decode(st, fpath, func(e []string) error {
+		rec, err := parse(e)
+		if rec == nil {
+			return err
+		}
+
+		switch r := rec.(type) {
+		case *logentry.MessageRecord:
+			if r.RefChannelID != "" {
+				refchans[r.ID] = r.RefChannelID
+			}
+
+			for _, match := range emojiRegexp.FindAllStringSubmatch(r.Content, -1) {
+				id, animated := match[2], match[1] == "a"
+				fetch(dl, "downloading external emoji "+id, policyItem(cdndl.KindEmoji, cdndl.EmojiURL(id, animated), gid, cid), func() error { return dl.Emoji(id, animated) })
+			}
+		case *logentry.AttachmentRecord:
+			if r.Snapshot {
+				snapshotAttachments = append(snapshotAttachments, r)
+			} else {
+				fetchAttachment(dl, gid, cid, cid, r)
+			}
+		case *logentry.EmbedMediaRecord:
+			URL := r.ProxyURL
+			if URL == "" {
+				URL = r.URL
+			}
+			if URL != "" {
+				fetch(dl, "downloading "+r.Kind+" of an embed in "+r.MessageID, policyItem(cdndl.KindEmbed, URL, gid, cid), func() error { return dl.EmbedMedia(URL) })
+			}
+		case *logentry.ReactionRecord:
+			if i := strings.LastIndex(r.Emoji, ":"); i != -1 {
+				id := r.Emoji[i+1:]
+				fetch(dl, "downloading external emoji "+id, policyItem(cdndl.KindEmoji, cdndl.EmojiURL(id, false), gid, cid), func() error { return dl.EmojiGuess(id) })
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, r := range snapshotAttachments {
+		if refchan := refchans[r.MessageID]; refchan != "" {
+			fetchAttachment(dl, gid, cid, refchan, r)
+		} else {
+			log.Printf("warning: %s: unknown channel for attachment %s, skipping", fpath, r.ID)
+		}
+	}
+
+	return nil
+}
+
+// fetchAttachment queues an attachment stored under the srccid channel
+// for a message in the cid channel.
+func fetchAttachment(dl *cdndl.Manager, gid, cid, srccid string, r *logentry.AttachmentRecord) {
+	if r.Filename == "" {
+		log.Printf("warning: no filename for attachment %s, skipping", r.ID)
+		return
+	}
+
+	URL := cdndl.AttachmentURL(srccid, r.ID, r.Filename)
+	it := policyItem(cdndl.KindAttachment, URL, gid, cid)
+	if r.Size != 0 {
+		it.Size = int64(r.Size)
+	}
+	it.ContentType = r.ContentType
+
+	fetch(dl, "downloading attachment "+r.ID+" for message "+r.MessageID, it, func() error { return dl.Attachment(URL) })
+}
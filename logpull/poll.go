@@ -0,0 +1,244 @@
+package logpull
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/tsudoko/pullcord/logentry"
+	"github.com/tsudoko/pullcord/logfile"
+	"github.com/tsudoko/pullcord/storage"
+	"github.com/tsudoko/pullcord/tsv"
+)
+
+const pollVotersLimit = 100
+
+// discordgo's PollAnswerVoters doesn't support pagination
+func (p *Puller) pollVoters(cid, mid string, aid int, after string) ([]*discordgo.User, error) {
+	endpoint := discordgo.EndpointPollAnswerVoters(cid, mid, aid)
+	query := "?limit=" + strconv.Itoa(pollVotersLimit)
+	if after != "" {
+		query += "&after=" + after
+	}
+
+	var body []byte
+	err := p.Retry.Do("getting voters for answer "+strconv.Itoa(aid)+" to "+mid, func() (err error) {
+		body, err = p.d.RequestWithBucketID("GET", endpoint+query, nil, endpoint)
+		return
+	}, temporaryREST)
+	if err != nil {
+		return nil, err
+	}
+
+	var r struct {
+		Users []*discordgo.User `json:"users"`
+	}
+	err = json.Unmarshal(body, &r)
+	return r.Users, err
+}
+
+type pollVoteKey struct {
+	answer int
+	user   string // empty for votes of users who couldn't be listed
+}
+
+// pollState is the last recorded state of a poll.
+type pollState struct {
+	final   bool
+	results map[int]int
+	votes   map[pollVoteKey]int
+}
+
+func newPollState() *pollState {
+	return &pollState{results: make(map[int]int), votes: make(map[pollVoteKey]int)}
+}
+
+// openPolls returns the recorded state of polls in a channel log which
+// weren't finalized yet, by message ID.
+func openPolls(st storage.Storage, filename string) (map[string]*pollState, error) {
+	polls := make(map[string]*pollState)
+	state := func(mid string) *pollState {
+		if polls[mid] == nil {
+			polls[mid] = newPollState()
+		}
+		return polls[mid]
+	}
+
+	err := logfile.Decode(st, filename, func(e []string) error {
+		if len(e) <= logentry.HID {
+			return logentry.ErrShort
+		}
+		switch e[logentry.HType] {
+		case "poll", "pollresult", "pollvote":
+		default:
+			return nil
+		}
+
+		rec, err := logentry.Parse(e)
+		if err != nil {
+			return err
+		}
+		del := e[logentry.HOp] == "del"
+
+		switch r := rec.(type) {
+		case *logentry.PollRecord:
+			state(r.ID)
+		case *logentry.PollResultRecord:
+			s := state(r.MessageID)
+			s.results[r.ID] = r.Count
+			s.final = s.final || r.Finalized
+		case *logentry.PollVoteRecord:
+			s := state(r.MessageID)
+			key := pollVoteKey{r.AnswerID, r.UserID}
+			if del {
+				delete(s.votes, key)
+			} else {
+				s.votes[key] = r.Count
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for mid, s := range polls {
+		if s.final {
+			delete(polls, mid)
+		}
+	}
+	return polls, nil
+}
+
+// refreshPolls writes the current results of polls which weren't finalized
+// when they were last pulled, if they changed since.
+func (p *Puller) refreshPolls(w io.Writer, c *discordgo.Channel, filename string) error {
+	polls, err := openPolls(p.st, filename)
+	if err != nil {
+		return &PullError{"reading polls", err}
+	}
+
+	var ids []string
+	for mid := range polls {
+		ids = append(ids, mid)
+	}
+	sort.Strings(ids)
+
+	for _, mid := range ids {
+		var m *discordgo.Message
+		err := p.Retry.Do("getting message "+mid, func() (err error) {
+			m, err = p.d.ChannelMessage(c.ID, mid)
+			return
+		}, temporaryREST)
+		if r, ok := err.(*discordgo.RESTError); ok && r.Message != nil && (r.Message.Code == 10008 || r.Message.Code == 50001) { // Unknown Message, Missing Access
+			log.Printf("[%s/%s] warning: can't refresh poll %s (%s)", c.GuildID, c.ID, mid, r.Message.Message)
+			continue
+		}
+		if err != nil {
+			return &PullError{"getting message " + mid, err}
+		}
+
+		if m.Poll != nil {
+			if err := p.pullPoll(w, c, m, polls[mid]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// pullPoll writes a poll with its results and votes. If prev is not nil, the
+// poll was written before and only results and votes which changed since are
+// written.
+func (p *Puller) pullPoll(w io.Writer, c *discordgo.Channel, m *discordgo.Message, prev *pollState) error {
+	poll := m.Poll
+	if prev == nil {
+		tsv.Write(w, logentry.Make("history", "add", &logentry.Poll{Poll: *poll, MessageID: m.ID}))
+
+		for _, a := range poll.Answers {
+			tsv.Write(w, logentry.Make("history", "add", &logentry.PollAnswer{PollAnswer: a, MessageID: m.ID}))
+		}
+		prev = newPollState()
+	}
+
+	cur := newPollState()
+	if poll.Results != nil {
+		cur.final = poll.Results.Finalized
+		for _, r := range poll.Results.AnswerCounts {
+			cur.results[r.ID] = r.Count
+		}
+	}
+
+	// finalized polls get a result for every answer, so that they're known
+	// to be final even if nobody voted
+	for _, a := range poll.Answers {
+		count, ok := cur.results[a.AnswerID]
+		last, recorded := prev.results[a.AnswerID]
+		changed := !recorded || count != last || cur.final != prev.final
+		if (ok || recorded || cur.final) && changed {
+			r := discordgo.PollAnswerCount{ID: a.AnswerID, Count: count}
+			tsv.Write(w, logentry.Make("history", "add", &logentry.PollResult{PollAnswerCount: r, MessageID: m.ID, Finalized: cur.final}))
+		}
+	}
+
+	for _, a := range poll.Answers {
+		if cur.results[a.AnswerID] == 0 {
+			continue
+		}
+
+		listed := 0
+		after := ""
+		for {
+			users, err := p.pollVoters(c.ID, m.ID, a.AnswerID, after)
+			if err != nil {
+				return &PullError{"getting voters for answer " + strconv.Itoa(a.AnswerID) + " to " + m.ID, err}
+			}
+
+			for _, u := range users {
+				cur.votes[pollVoteKey{a.AnswerID, u.ID}] = 1
+			}
+			listed += len(users)
+
+			if len(users) < pollVotersLimit {
+				break
+			}
+			after = users[len(users)-1].ID
+		}
+
+		if cur.results[a.AnswerID] > listed {
+			cur.votes[pollVoteKey{a.AnswerID, ""}] = cur.results[a.AnswerID] - listed
+		}
+	}
+
+	for _, k := range sortedVotes(cur.votes) {
+		if n, ok := prev.votes[k]; !ok || n != cur.votes[k] {
+			tsv.Write(w, logentry.Make("history", "add", &logentry.PollVote{UserID: k.user, MessageID: m.ID, AnswerID: k.answer, Count: cur.votes[k]}))
+		}
+	}
+	for _, k := range sortedVotes(prev.votes) {
+		if _, ok := cur.votes[k]; !ok {
+			tsv.Write(w, logentry.Make("history", "del", &logentry.PollVote{UserID: k.user, MessageID: m.ID, AnswerID: k.answer, Count: prev.votes[k]}))
+		}
+	}
+
+	return nil
+}
+
+func sortedVotes(votes map[pollVoteKey]int) []pollVoteKey {
+	var keys []pollVoteKey
+	for k := range votes {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].answer != keys[j].answer {
+			return keys[i].answer < keys[j].answer
+		}
+		return keys[i].user < keys[j].user
+	})
+	return keys
+}
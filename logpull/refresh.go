@@ -0,0 +1,41 @@
+package logpull
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var endpointRefreshURLs = discordgo.EndpointAPI + "attachments/refresh-urls"
+
+// AttachmentRefresher returns a function which gets freshly signed
+// attachment URLs, meant to be used as cdndl.Manager.Refresh.
+func AttachmentRefresher(d *discordgo.Session) func(string) (string, error) {
+	return func(URL string) (string, error) {
+		req := struct {
+			URLs []string `json:"attachment_urls"`
+		}{[]string{URL}}
+
+		body, err := d.RequestWithBucketID("POST", endpointRefreshURLs, req, endpointRefreshURLs)
+		if err != nil {
+			return "", err
+		}
+
+		var r struct {
+			URLs []struct {
+				Original  string `json:"original"`
+				Refreshed string `json:"refreshed"`
+			} `json:"refreshed_urls"`
+		}
+		if err := json.Unmarshal(body, &r); err != nil {
+			return "", err
+		}
+
+		if len(r.URLs) == 0 || r.URLs[0].Refreshed == "" {
+			return "", errors.New("no refreshed URL returned for " + URL)
+		}
+
+		return r.URLs[0].Refreshed, nil
+	}
+}
@@ -2,15 +2,24 @@ package logpull
 
 import (
 	"strings"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
-)
 
-func isDiscordError(e error, code int) bool {
-	r, ok := e.(*discordgo.RESTError)
-	return ok && r.Message != nil && r.Message.Code == code
-}
+	"github.com/tsudoko/pullcord/retry"
+)
 
 func isBotSession(d *discordgo.Session) bool {
 	return strings.HasPrefix(strings.ToLower(d.Token), "bot ")
 }
+
+// discordgo handles rate limits by itself, but gives up on server errors
+func temporaryREST(err error) (bool, time.Duration) {
+	if r, ok := err.(*discordgo.RESTError); ok {
+		if r.Response == nil {
+			return false, 0
+		}
+		return retry.TemporaryStatus(r.Response.StatusCode), retry.After(r.Response.Header)
+	}
+	return retry.Temporary(err), 0
+}
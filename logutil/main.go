@@ -1,47 +1,38 @@
 package logutil
 
 import (
-	"bufio"
-	"os"
-
 	"github.com/tsudoko/pullcord/logcache"
 	"github.com/tsudoko/pullcord/logentry"
-	"github.com/tsudoko/pullcord/tsv"
+	"github.com/tsudoko/pullcord/logfile"
+	"github.com/tsudoko/pullcord/storage"
 )
 
-func LastMessageID(fpath string) (id string, err error) {
-	f, err := os.Open(fpath)
-	if err != nil {
-		return
-	}
-
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		entry := tsv.Read(scanner)
-		if entry[logentry.HOp] == "add" && entry[logentry.HType] == "message" {
-			id = entry[logentry.HID]
+// records calls f for every record of a log.
+func records(st storage.Storage, fpath string, f func(e []string)) error {
+	return logfile.Decode(st, fpath, func(e []string) error {
+		if len(e) <= logentry.HID {
+			return logentry.ErrShort
 		}
-	}
 
-	err = scanner.Err()
-	return
+		f(e)
+		return nil
+	})
 }
 
-func AllIDs(fpath string, ids *logcache.IDs) (err error) {
-	f, err := os.Open(fpath)
-	if err != nil {
-		return
-	}
+func LastMessageID(st storage.Storage, fpath string) (id string, err error) {
+	err = records(st, fpath, func(e []string) {
+		if e[logentry.HOp] == "add" && e[logentry.HType] == "message" {
+			id = e[logentry.HID]
+		}
+	})
+	return
+}
 
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		e := tsv.Read(scanner)
+func AllIDs(st storage.Storage, fpath string, ids *logcache.IDs) error {
+	return records(st, fpath, func(e []string) {
 		if (*ids)[e[logentry.HType]] == nil {
 			(*ids)[e[logentry.HType]] = make(map[string]bool)
 		}
 		(*ids)[e[logentry.HType]][e[logentry.HID]] = true
-	}
-
-	err = scanner.Err()
-	return
+	})
 }
@@ -0,0 +1,142 @@
+package logutil
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/tsudoko/pullcord/logentry"
+	"github.com/tsudoko/pullcord/logfile"
+	"github.com/tsudoko/pullcord/storage"
+	"github.com/tsudoko/pullcord/tsv"
+)
+
+// Older versions of pullcord wrote del records by copying the cached add
+// record of the object, which could be damaged in two ways: the old TSV
+// decoder turned an escaped backslash followed by "n" or "t" into a backslash
+// followed by a control character, and records written during the same pull
+// were cached already escaped.
+
+// ErrDamaged is reported for del records damaged by older versions.
+var ErrDamaged = errors.New("damaged deletion record")
+
+// damaged reports if the fields of del are a damaged copy of those of add.
+func damaged(add, del []string) bool {
+	legacy := make([]string, len(add))
+	escaped := make([]string, len(add))
+	for i, f := range add {
+		legacy[i] = tsv.UnescapeLegacy(tsv.Escape(f))
+		escaped[i] = tsv.Escape(f)
+	}
+
+	for _, v := range [][]string{legacy, escaped} {
+		if !fieldsEqual(v[logentry.HID+1:], add[logentry.HID+1:]) && fieldsEqual(v[logentry.HID+1:], del[logentry.HID+1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldsEqual compares fields, missing fields are equal to empty ones.
+func fieldsEqual(a, b []string) bool {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y string
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
+
+// Repair finds del records damaged by older versions of pullcord in a log and
+// its segments, calls report for each one with ErrDamaged and restores them
+// from the add records they were copied from, unless dryRun is set. If strict
+// is set, records with invalid escape sequences are reported as well, with
+// tsv.ErrEscape, and kept as they are.
+func Repair(st storage.Storage, fpath string, dryRun, strict bool, report func(part string, line int, err error)) error {
+	parts, err := logfile.Parts(st, fpath)
+	if err != nil {
+		return err
+	}
+
+	adds := make(map[[2]string][]string)
+	for _, part := range parts {
+		if err := repairPart(st, part, adds, dryRun, strict, report); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func repairPart(st storage.Storage, part string, adds map[[2]string][]string, dryRun, strict bool, report func(part string, line int, err error)) error {
+	r, err := logfile.OpenPart(st, part)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tmp, err := os.CreateTemp("", "pullcord-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	changed := false
+	dec := tsv.NewDecoder(r)
+	dec.Strict = strict
+	for {
+		e, err := dec.Decode()
+		if err == io.EOF {
+			break
+		} else if errors.Is(err, tsv.ErrEscape) {
+			// the line was read, the decoder can go on
+			report(part, dec.Line(), tsv.ErrEscape)
+			e = nil
+		} else if perr, ok := err.(*tsv.ParseError); ok {
+			perr.File = part
+			return perr
+		} else if err != nil {
+			return err
+		}
+
+		if len(e) > logentry.HID {
+			key := [2]string{e[logentry.HType], e[logentry.HID]}
+			switch e[logentry.HOp] {
+			case "add":
+				adds[key] = e
+			case "del":
+				if add := adds[key]; add != nil && damaged(add, e) {
+					report(part, dec.Line(), ErrDamaged)
+					fixed := append([]string{}, add...)
+					copy(fixed, e[:logentry.HID+1])
+					if err := tsv.Write(tmp, fixed); err != nil {
+						return err
+					}
+					changed = true
+					continue
+				}
+			}
+		}
+
+		if _, err := io.WriteString(tmp, dec.Raw()+"\n"); err != nil {
+			return err
+		}
+	}
+
+	if !changed || dryRun {
+		return nil
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return logfile.PutPart(st, part, tmp)
+}
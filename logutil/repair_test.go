@@ -0,0 +1,184 @@
+package logutil
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/tsudoko/pullcord/logfile"
+	"github.com/tsudoko/pullcord/storage"
+	"github.com/tsudoko/pullcord/tsv"
+)
+
+const testLog = "channels/1/guild.tsv"
+
+func header(op, id string) []string {
+	return []string{"2020-01-01T00:00:00.000000+00:00", "history", op, "role", id}
+}
+
+func record(op, id string, fields ...string) []string {
+	return append(header(op, id), fields...)
+}
+
+func appendRecords(t *testing.T, st storage.Storage, records ...[]string) {
+	t.Helper()
+	w, err := st.Append(testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		tsv.Write(w, r)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func appendRaw(t *testing.T, st storage.Storage, line string) {
+	t.Helper()
+	w, err := st.Append(testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, line+"\n")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type report struct {
+	line int
+	err  error
+}
+
+func repair(t *testing.T, st storage.Storage, dryRun, strict bool) []report {
+	t.Helper()
+	var reports []report
+	err := Repair(st, testLog, dryRun, strict, func(part string, line int, err error) {
+		if part != testLog {
+			t.Errorf("reported part %s", part)
+		}
+		reports = append(reports, report{line, err})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reports
+}
+
+func TestRepair(t *testing.T) {
+	st := storage.Dir(t.TempDir())
+
+	legacy := record("add", "1", `C:\new`, "x")
+	escaped := record("add", "2", "a\tb", "y")
+	intact := record("add", "3", `C:\new`, "z")
+
+	appendRecords(t, st, legacy, escaped, intact)
+	// adds in older segments are used too
+	if err := logfile.Rotate(st, testLog, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	appendRecords(t, st,
+		record("del", "1", tsv.UnescapeLegacy(tsv.Escape(`C:\new`)), "x"),
+		record("del", "2", tsv.Escape("a\tb"), "y"),
+		record("del", "3", `C:\new`, "z"),
+		// changed since the add, not a copy
+		record("del", "1", "other", "x"),
+	)
+	appendRaw(t, st, `2020-01-01T00:00:00.000000+00:00	history	add	role	4	bad\q`)
+
+	before, err := os.ReadFile(filepath.Join(string(st), filepath.FromSlash(testLog)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []report{{1, ErrDamaged}, {2, ErrDamaged}}
+	if got := repair(t, st, true, false); !reflect.DeepEqual(got, want) {
+		t.Errorf("dry run: got %v, want %v", got, want)
+	}
+
+	wantStrict := append(want, report{5, tsv.ErrEscape})
+	if got := repair(t, st, true, true); !reflect.DeepEqual(got, wantStrict) {
+		t.Errorf("strict dry run: got %v, want %v", got, wantStrict)
+	}
+
+	after, err := os.ReadFile(filepath.Join(string(st), filepath.FromSlash(testLog)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) != string(after) {
+		t.Fatal("dry run changed the log")
+	}
+
+	if got := repair(t, st, false, true); !reflect.DeepEqual(got, wantStrict) {
+		t.Errorf("repair: got %v, want %v", got, wantStrict)
+	}
+
+	var dels [][]string
+	var last string
+	err = logfile.Decode(st, testLog, func(e []string) error {
+		if e[2] == "del" {
+			dels = append(dels, e)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDels := [][]string{
+		record("del", "1", `C:\new`, "x"),
+		record("del", "2", "a\tb", "y"),
+		record("del", "3", `C:\new`, "z"),
+		record("del", "1", "other", "x"),
+	}
+	if !reflect.DeepEqual(dels, wantDels) {
+		t.Errorf("got records\n%q\nwant\n%q", dels, wantDels)
+	}
+
+	// invalid lines are kept as they are
+	r, err := st.Open(testLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	dec := tsv.NewDecoder(r)
+	for {
+		if _, err := dec.Decode(); err == io.EOF {
+			break
+		}
+		last = dec.Raw()
+	}
+	if want := `2020-01-01T00:00:00.000000+00:00	history	add	role	4	bad\q`; last != want {
+		t.Errorf("last line %q, want %q", last, want)
+	}
+
+	if got := repair(t, st, false, false); len(got) != 0 {
+		t.Errorf("repaired log: got %v", got)
+	}
+}
+
+func TestDamaged(t *testing.T) {
+	tests := []struct {
+		add, del string
+		damaged  bool
+	}{
+		{`C:\new`, tsv.UnescapeLegacy(tsv.Escape(`C:\new`)), true},
+		{`\\t`, tsv.UnescapeLegacy(tsv.Escape(`\\t`)), true},
+		{"a\tb", tsv.Escape("a\tb"), true},
+		{"line\nbreak", tsv.Escape("line\nbreak"), true},
+		{`C:\new`, `C:\new`, false},
+		// fields which don't change when escaped can't be damaged
+		{"plain", "plain", false},
+		{"plain", "other", false},
+	}
+
+	for _, tt := range tests {
+		add, del := record("add", "1", tt.add), record("del", "1", tt.del)
+		if got := damaged(add, del); got != tt.damaged {
+			t.Errorf("damaged(%q, %q) = %v, want %v", tt.add, tt.del, got, tt.damaged)
+		}
+	}
+}
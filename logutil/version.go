@@ -0,0 +1,63 @@
+package logutil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/tsudoko/pullcord/logentry"
+	"github.com/tsudoko/pullcord/storage"
+	"github.com/tsudoko/pullcord/tsv"
+)
+
+// FormatPath holds the format version an archive was last written with.
+const FormatPath = "format.tsv"
+
+// FormatVersion returns the format version an archive was last written with,
+// or 0 if it isn't recorded.
+func FormatVersion(st storage.Storage) (int, error) {
+	f, err := st.Open(FormatPath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	e, err := tsv.NewDecoder(f).Decode()
+	if err == io.EOF {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	v, err := strconv.Atoi(e[0])
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid version %q", FormatPath, e[0])
+	}
+	return v, nil
+}
+
+// UpdateFormatVersion records that an archive is being written with the
+// current format version. It fails if the archive was written with a newer
+// one, which older versions could damage.
+func UpdateFormatVersion(st storage.Storage) error {
+	v, err := FormatVersion(st)
+	if err != nil {
+		return err
+	}
+	if v > logentry.Version {
+		return fmt.Errorf("archive format version %d is newer than the supported version %d", v, logentry.Version)
+	}
+	if v == logentry.Version {
+		return nil
+	}
+
+	var b bytes.Buffer
+	if err := tsv.Write(&b, []string{strconv.Itoa(logentry.Version)}); err != nil {
+		return err
+	}
+	return st.Put(FormatPath, bytes.NewReader(b.Bytes()))
+}
@@ -0,0 +1,252 @@
+package render
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/tsudoko/pullcord/archive"
+)
+
+// Resolver looks up the names of mentioned objects, ok is false for unknown
+// ones.
+type Resolver interface {
+	User(id string) (name string, ok bool)
+	Channel(id string) (name string, ok bool)
+	Role(id string) (name string, color int, ok bool)
+}
+
+// StateResolver resolves mentions through a reconstructed guild state. Users
+// who weren't members at the time are resolved as well.
+type StateResolver struct {
+	*archive.State
+}
+
+func (s StateResolver) User(id string) (string, bool) {
+	if m := s.Members[id]; m != nil && m.Nick != "" {
+		return m.Nick, true
+	}
+	if u := s.Users[id]; u != nil {
+		return u.Username, true
+	}
+	return "", false
+}
+
+func (s StateResolver) Channel(id string) (string, bool) {
+	if c := s.Channels[id]; c != nil {
+		return c.Name, true
+	}
+	return "", false
+}
+
+func (s StateResolver) Role(id string) (string, int, bool) {
+	if r := s.Roles[id]; r != nil {
+		return r.Name, r.Color, true
+	}
+	return "", 0, false
+}
+
+// mentions resolves mentions with r, unknown ones are shown by their IDs.
+type mentions struct {
+	r Resolver
+}
+
+func (m mentions) user(id string) string {
+	if m.r != nil {
+		if name, ok := m.r.User(id); ok {
+			return "@" + name
+		}
+	}
+	return "@" + id
+}
+
+func (m mentions) channel(id string) string {
+	if m.r != nil {
+		if name, ok := m.r.Channel(id); ok {
+			return "#" + name
+		}
+	}
+	return "#" + id
+}
+
+func (m mentions) role(id string) (string, int) {
+	if m.r != nil {
+		if name, color, ok := m.r.Role(id); ok {
+			return "@" + name, color
+		}
+	}
+	return "@" + id, 0
+}
+
+var timestampLayouts = map[string]string{
+	"t": "15:04",
+	"T": "15:04:05",
+	"d": "02/01/2006",
+	"D": "2 January 2006",
+	"f": "2 January 2006 15:04",
+	"F": "Monday, 2 January 2006 15:04",
+	"R": "2 January 2006 15:04", // relative to the time it's read, which isn't known
+}
+
+// FormatTimestamp formats a timestamp token in one of Discord's styles, in
+// local time.
+func FormatTimestamp(t time.Time, style string) string {
+	layout, ok := timestampLayouts[style]
+	if !ok {
+		layout = timestampLayouts["f"]
+	}
+	return t.Local().Format(layout)
+}
+
+// HTMLOptions controls HTML rendering. All fields are optional.
+type HTMLOptions struct {
+	Resolver Resolver
+	// EmojiURL returns the URL of a custom emoji image.
+	EmojiURL func(id string, animated bool) string
+	// ChannelURL returns the URL of a mentioned channel, or an empty string.
+	ChannelURL func(id string) string
+}
+
+// HTML renders nodes as HTML. Links other than http and https ones are
+// rendered as text.
+func HTML(nodes []*Node, o *HTMLOptions) template.HTML {
+	if o == nil {
+		o = new(HTMLOptions)
+	}
+	var b strings.Builder
+	writeHTML(&b, nodes, o)
+	return template.HTML(b.String())
+}
+
+func writeHTML(b *strings.Builder, nodes []*Node, o *HTMLOptions) {
+	esc := template.HTMLEscapeString
+	m := mentions{o.Resolver}
+
+	wrap := func(open, close string, n *Node) {
+		b.WriteString(open)
+		writeHTML(b, n.Children, o)
+		b.WriteString(close)
+	}
+
+	for _, n := range nodes {
+		switch n.Kind {
+		case Text:
+			b.WriteString(esc(n.Text))
+		case LineBreak:
+			b.WriteString("<br>")
+		case Bold:
+			wrap("<strong>", "</strong>", n)
+		case Italic:
+			wrap("<em>", "</em>", n)
+		case Underline:
+			wrap("<u>", "</u>", n)
+		case Strike:
+			wrap("<s>", "</s>", n)
+		case Spoiler:
+			wrap(`<span class="spoiler">`, "</span>", n)
+		case Code:
+			b.WriteString("<code>" + esc(n.Text) + "</code>")
+		case CodeBlock:
+			if n.Lang != "" {
+				fmt.Fprintf(b, `<pre><code class="language-%s">`, esc(n.Lang))
+			} else {
+				b.WriteString("<pre><code>")
+			}
+			b.WriteString(esc(n.Text) + "</code></pre>")
+		case Quote:
+			wrap("<blockquote>", "</blockquote>", n)
+		case Heading:
+			wrap(fmt.Sprintf("<h%d>", n.Level), fmt.Sprintf("</h%d>", n.Level), n)
+		case Link:
+			fmt.Fprintf(b, `<a href="%s">`, esc(n.URL))
+			if len(n.Children) > 0 {
+				writeHTML(b, n.Children, o)
+			} else {
+				b.WriteString(esc(n.URL))
+			}
+			b.WriteString("</a>")
+		case UserMention:
+			fmt.Fprintf(b, `<span class="mention" title="%s">%s</span>`, n.ID, esc(m.user(n.ID)))
+		case ChannelMention:
+			href := ""
+			if o.ChannelURL != nil {
+				href = o.ChannelURL(n.ID)
+			}
+			if href != "" {
+				fmt.Fprintf(b, `<a class="mention" href="%s">%s</a>`, esc(href), esc(m.channel(n.ID)))
+			} else {
+				fmt.Fprintf(b, `<span class="mention" title="%s">%s</span>`, n.ID, esc(m.channel(n.ID)))
+			}
+		case RoleMention:
+			name, color := m.role(n.ID)
+			if color != 0 {
+				fmt.Fprintf(b, `<span class="mention" style="color: #%06x" title="%s">%s</span>`, color, n.ID, esc(name))
+			} else {
+				fmt.Fprintf(b, `<span class="mention" title="%s">%s</span>`, n.ID, esc(name))
+			}
+		case Everyone:
+			fmt.Fprintf(b, `<span class="mention">%s</span>`, esc(n.Text))
+		case Emoji:
+			if o.EmojiURL != nil {
+				fmt.Fprintf(b, `<img class="emoji" src="%s" alt=":%s:" title=":%s:">`, esc(o.EmojiURL(n.ID, n.Animated)), esc(n.Text), esc(n.Text))
+			} else {
+				b.WriteString(esc(":" + n.Text + ":"))
+			}
+		case Timestamp:
+			fmt.Fprintf(b, `<time datetime="%s" title="%s">%s</time>`,
+				n.Time.UTC().Format(time.RFC3339), esc(FormatTimestamp(n.Time, "F")), esc(FormatTimestamp(n.Time, n.Style)))
+		}
+	}
+}
+
+// PlainText renders nodes as text without formatting.
+func PlainText(nodes []*Node, r Resolver) string {
+	var b strings.Builder
+	writeText(&b, nodes, mentions{r})
+	return b.String()
+}
+
+func writeText(b *strings.Builder, nodes []*Node, m mentions) {
+	for _, n := range nodes {
+		switch n.Kind {
+		case Text, Everyone:
+			b.WriteString(n.Text)
+		case LineBreak:
+			b.WriteString("\n")
+		case Code:
+			b.WriteString(n.Text)
+		case CodeBlock:
+			b.WriteString(n.Text + "\n")
+		case Quote:
+			var q strings.Builder
+			writeText(&q, n.Children, m)
+			for _, line := range strings.Split(strings.TrimSuffix(q.String(), "\n"), "\n") {
+				b.WriteString("> " + line + "\n")
+			}
+		case Heading:
+			writeText(b, n.Children, m)
+			b.WriteString("\n")
+		case Link:
+			if len(n.Children) > 0 {
+				writeText(b, n.Children, m)
+				b.WriteString(" (" + n.URL + ")")
+			} else {
+				b.WriteString(n.URL)
+			}
+		case UserMention:
+			b.WriteString(m.user(n.ID))
+		case ChannelMention:
+			b.WriteString(m.channel(n.ID))
+		case RoleMention:
+			name, _ := m.role(n.ID)
+			b.WriteString(name)
+		case Emoji:
+			b.WriteString(":" + n.Text + ":")
+		case Timestamp:
+			b.WriteString(FormatTimestamp(n.Time, n.Style))
+		default:
+			writeText(b, n.Children, m)
+		}
+	}
+}
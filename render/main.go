@@ -0,0 +1,420 @@
+// Package render parses Discord markdown and the tokens used in message
+// content, such as mentions and custom emoji, and renders them as HTML or
+// plain text.
+package render
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+type Kind int
+
+const (
+	Text      Kind = iota // Text
+	LineBreak             // a line feed outside of code blocks
+	Bold
+	Italic
+	Underline
+	Strike
+	Spoiler
+	Code      // inline code, Text
+	CodeBlock // Text, Lang
+	Quote     // block quote
+	Heading   // Level
+	Link      // URL, masked links have Children
+	UserMention
+	ChannelMention
+	RoleMention
+	Everyone // @everyone or @here, Text
+	Emoji    // custom emoji, Text is the name, Animated
+	Timestamp
+)
+
+type Node struct {
+	Kind     Kind
+	Text     string
+	ID       string // of mentioned objects and custom emoji
+	URL      string
+	Lang     string
+	Level    int
+	Animated bool
+	Time     time.Time
+	Style    string // of timestamps, one of tTdDfFR
+	Children []*Node
+}
+
+// Parse parses the content of a message. It never fails, anything which
+// isn't valid markdown is kept as text.
+func Parse(s string) []*Node {
+	p := &parser{}
+	p.blocks(s)
+	return p.nodes
+}
+
+type parser struct {
+	nodes []*Node
+	text  strings.Builder
+}
+
+func (p *parser) flush() {
+	if p.text.Len() > 0 {
+		p.nodes = append(p.nodes, &Node{Kind: Text, Text: p.text.String()})
+		p.text.Reset()
+	}
+}
+
+func (p *parser) add(n *Node) {
+	p.flush()
+	p.nodes = append(p.nodes, n)
+}
+
+func parseInline(s string) []*Node {
+	p := &parser{}
+	p.inline(s)
+	p.flush()
+	return p.nodes
+}
+
+// blocks parses block quotes and headings, which can only start at the
+// beginning of a line, and passes everything else to inline.
+func (p *parser) blocks(s string) {
+	for s != "" {
+		line := s
+		rest := ""
+		if i := strings.IndexByte(s, '\n'); i != -1 {
+			line, rest = s[:i], s[i+1:]
+		}
+
+		switch {
+		case strings.HasPrefix(line, ">>> "):
+			p.add(&Node{Kind: Quote, Children: Parse(s[4:])})
+			return
+		case strings.HasPrefix(line, "> "):
+			// consecutive quoted lines form a single quote
+			var quoted []string
+			for strings.HasPrefix(line, "> ") {
+				quoted = append(quoted, line[2:])
+				s = rest
+				line, rest = s, ""
+				if i := strings.IndexByte(s, '\n'); i != -1 {
+					line, rest = s[:i], s[i+1:]
+				}
+			}
+			p.add(&Node{Kind: Quote, Children: parseInline(strings.Join(quoted, "\n"))})
+			continue
+		case heading(line) > 0:
+			level := heading(line)
+			p.add(&Node{Kind: Heading, Level: level, Children: parseInline(line[level+1:])})
+			s = rest
+			continue
+		}
+
+		// inline elements can span lines, e.g. code blocks, so parse up to
+		// the next line which starts a block
+		end := len(s)
+		for i := 0; i < len(s); i++ {
+			if s[i] == '\n' && startsBlock(s[i+1:]) {
+				end = i + 1
+				break
+			}
+		}
+		p.inline(s[:end])
+		s = s[end:]
+	}
+	p.flush()
+}
+
+// heading returns the level of a heading line, or 0.
+func heading(line string) int {
+	for level := 1; level <= 3; level++ {
+		prefix := strings.Repeat("#", level) + " "
+		if strings.HasPrefix(line, prefix) && strings.TrimSpace(line[len(prefix):]) != "" {
+			return level
+		}
+	}
+	return 0
+}
+
+func startsBlock(s string) bool {
+	line := s
+	if i := strings.IndexByte(s, '\n'); i != -1 {
+		line = s[:i]
+	}
+	return strings.HasPrefix(line, "> ") || strings.HasPrefix(line, ">>> ") || heading(line) > 0
+}
+
+// closing returns the index of the first delimiter d in s which isn't
+// escaped, or -1.
+func closing(s, d string) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.HasPrefix(s[i:], d) {
+			return i
+		}
+	}
+	return -1
+}
+
+// italicEnd returns the index of the "*" closing an italic span, skipping
+// over bold delimiters, or -1.
+func italicEnd(s string) int {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case strings.HasPrefix(s[i:], "**"):
+			i++
+		case s[i] == '*':
+			// a "*" after a space can neither close the span nor be
+			// a part of it
+			if i == 0 || unicode.IsSpace(rune(s[i-1])) {
+				return -1
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+func isWordByte(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// spans are delimited inline elements, longer delimiters first.
+var spans = []struct {
+	delim string
+	kind  Kind
+}{
+	{"||", Spoiler},
+	{"**", Bold},
+	{"__", Underline},
+	{"~~", Strike},
+}
+
+func (p *parser) inline(s string) {
+	for i := 0; i < len(s); {
+		if n, ok := p.token(s, i); ok {
+			i += n
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		p.text.WriteRune(r)
+		i += size
+	}
+}
+
+// token parses an inline element at s[i:], returning its length.
+func (p *parser) token(s string, i int) (int, bool) {
+	rest := s[i:]
+
+	switch rest[0] {
+	case '\\':
+		if len(rest) > 1 && strings.ContainsRune("\\*_~`|<>:#[]()@-", rune(rest[1])) {
+			p.text.WriteByte(rest[1])
+			return 2, true
+		}
+	case '\n':
+		p.add(&Node{Kind: LineBreak})
+		return 1, true
+	case '`':
+		if strings.HasPrefix(rest, "```") {
+			if j := strings.Index(rest[3:], "```"); j != -1 {
+				body := rest[3 : 3+j]
+				lang := ""
+				if k := strings.IndexByte(body, '\n'); k != -1 && (k == 0 || isLang(body[:k])) {
+					lang, body = body[:k], body[k+1:]
+				}
+				if strings.TrimSpace(body) != "" {
+					p.add(&Node{Kind: CodeBlock, Text: strings.TrimSuffix(body, "\n"), Lang: lang})
+					return 6 + j, true
+				}
+			}
+		}
+		for _, d := range []string{"``", "`"} {
+			if strings.HasPrefix(rest, d) {
+				if j := strings.Index(rest[len(d):], d); j > 0 {
+					p.add(&Node{Kind: Code, Text: strings.TrimSpace(rest[len(d) : len(d)+j])})
+					return 2*len(d) + j, true
+				}
+			}
+		}
+	case '*', '_', '~', '|':
+		for _, sp := range spans {
+			if !strings.HasPrefix(rest, sp.delim) {
+				continue
+			}
+			if j := closing(rest[2:], sp.delim); j > 0 {
+				// "***" closes bold after an italic span
+				if sp.kind == Bold && strings.HasPrefix(rest[2+j:], "***") {
+					j++
+				}
+				p.add(&Node{Kind: sp.kind, Children: parseInline(rest[2 : 2+j])})
+				return 4 + j, true
+			}
+		}
+		if rest[0] == '*' && len(rest) > 1 && rest[1] != '*' && !unicode.IsSpace(rune(rest[1])) {
+			if j := italicEnd(rest[1:]); j > 0 {
+				p.add(&Node{Kind: Italic, Children: parseInline(rest[1 : 1+j])})
+				return 2 + j, true
+			}
+		}
+		if rest[0] == '_' && (i == 0 || !isWordByte(s[i-1])) {
+			if j := closing(rest[1:], "_"); j > 0 && (2+j == len(rest) || !isWordByte(rest[2+j])) {
+				p.add(&Node{Kind: Italic, Children: parseInline(rest[1 : 1+j])})
+				return 2 + j, true
+			}
+		}
+	case '<':
+		if n, ok := p.angle(rest); ok {
+			return n, true
+		}
+	case '@':
+		for _, m := range []string{"@everyone", "@here"} {
+			if strings.HasPrefix(rest, m) {
+				p.add(&Node{Kind: Everyone, Text: m})
+				return len(m), true
+			}
+		}
+	case '[':
+		if n, ok := p.maskedLink(rest); ok {
+			return n, true
+		}
+	case 'h':
+		if n := urlLen(rest); n > 0 {
+			p.add(&Node{Kind: Link, URL: rest[:n]})
+			return n, true
+		}
+	}
+
+	return 0, false
+}
+
+func isLang(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !(r == '+' || r == '-' || r == '#' || r == '.' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+func isID(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// angle parses tokens in angle brackets: mentions, custom emoji, timestamps
+// and links which aren't embedded.
+func (p *parser) angle(s string) (int, bool) {
+	end := strings.IndexByte(s, '>')
+	if end == -1 {
+		return 0, false
+	}
+	tok := s[1:end]
+
+	switch {
+	case strings.HasPrefix(tok, "@&") && isID(tok[2:]):
+		p.add(&Node{Kind: RoleMention, ID: tok[2:]})
+	case strings.HasPrefix(tok, "@!") && isID(tok[2:]):
+		p.add(&Node{Kind: UserMention, ID: tok[2:]})
+	case strings.HasPrefix(tok, "@") && isID(tok[1:]):
+		p.add(&Node{Kind: UserMention, ID: tok[1:]})
+	case strings.HasPrefix(tok, "#") && isID(tok[1:]):
+		p.add(&Node{Kind: ChannelMention, ID: tok[1:]})
+	case strings.HasPrefix(tok, ":") || strings.HasPrefix(tok, "a:"):
+		parts := strings.Split(tok, ":")
+		if len(parts) != 3 || parts[1] == "" || !isID(parts[2]) {
+			return 0, false
+		}
+		p.add(&Node{Kind: Emoji, Text: parts[1], ID: parts[2], Animated: parts[0] == "a"})
+	case strings.HasPrefix(tok, "t:"):
+		parts := strings.Split(tok, ":")
+		if len(parts) > 3 {
+			return 0, false
+		}
+		sec, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		style := "f"
+		if len(parts) == 3 {
+			if len(parts[2]) != 1 || !strings.Contains("tTdDfFR", parts[2]) {
+				return 0, false
+			}
+			style = parts[2]
+		}
+		p.add(&Node{Kind: Timestamp, Time: time.Unix(sec, 0), Style: style})
+	case urlLen(tok) == len(tok) && tok != "":
+		p.add(&Node{Kind: Link, URL: tok})
+	default:
+		return 0, false
+	}
+	return end + 1, true
+}
+
+// maskedLink parses [text](url).
+func (p *parser) maskedLink(s string) (int, bool) {
+	end := closing(s[1:], "](")
+	if end <= 0 {
+		return 0, false
+	}
+	text := s[1 : 1+end]
+	rest := s[1+end+2:]
+
+	close := strings.IndexByte(rest, ')')
+	if close == -1 {
+		return 0, false
+	}
+	u := strings.TrimSpace(rest[:close])
+	// <url> hides the embed
+	u = strings.TrimSuffix(strings.TrimPrefix(u, "<"), ">")
+	if urlLen(u) != len(u) {
+		return 0, false
+	}
+
+	p.add(&Node{Kind: Link, URL: u, Children: parseInline(text)})
+	return 1 + end + 2 + close + 1, true
+}
+
+// urlLen returns the length of an http or https URL at the start of s, or 0.
+func urlLen(s string) int {
+	if !strings.HasPrefix(s, "http://") && !strings.HasPrefix(s, "https://") {
+		return 0
+	}
+
+	n := strings.IndexFunc(s, func(r rune) bool { return unicode.IsSpace(r) || r == '<' || r == '>' })
+	if n == -1 {
+		n = len(s)
+	}
+
+	// trailing punctuation is usually not a part of the URL, unless it
+	// closes a parenthesis opened in it
+	for n > 0 && strings.ContainsRune(".,:;!?\"')]", rune(s[n-1])) {
+		if s[n-1] == ')' && strings.Count(s[:n], "(") >= strings.Count(s[:n], ")") {
+			break
+		}
+		n--
+	}
+	if n <= strings.Index(s, "//")+2 {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,134 @@
+package render
+
+import (
+	"testing"
+)
+
+type fakeResolver struct{}
+
+func (fakeResolver) User(id string) (string, bool) {
+	if id == "1" {
+		return `<b>"user"</b>`, true
+	}
+	return "", false
+}
+
+func (fakeResolver) Channel(id string) (string, bool) {
+	if id == "2" {
+		return "general & more", true
+	}
+	return "", false
+}
+
+func (fakeResolver) Role(id string) (string, int, bool) {
+	if id == "3" {
+		return `"><script>`, 0xff0000, true
+	}
+	return "", 0, false
+}
+
+var testOptions = &HTMLOptions{
+	Resolver: fakeResolver{},
+	EmojiURL: func(id string, animated bool) string {
+		if animated {
+			return "emojis/" + id + ".gif"
+		}
+		return "emojis/" + id + `.png?"`
+	},
+	ChannelURL: func(id string) string {
+		if id == "2" {
+			return `2.html?a=1&b="x"`
+		}
+		return ""
+	},
+}
+
+var htmlTests = []struct {
+	in, out string
+}{
+	{"", ""},
+	{"plain <b>text</b> & more", "plain &lt;b&gt;text&lt;/b&gt; &amp; more"},
+	{"a\nb", "a<br>b"},
+
+	// nesting
+	{"**bold *italic* bold**", "<strong>bold <em>italic</em> bold</strong>"},
+	{"***both***", "<strong><em>both</em></strong>"},
+	{"||spoiler __under ~~strike~~__||", `<span class="spoiler">spoiler <u>under <s>strike</s></u></span>`},
+	{"_italic_ snake_case_name", "<em>italic</em> snake_case_name"},
+	{"**unclosed *italic*", "**unclosed <em>italic</em>"},
+	{"> quoted **bold**\n> second\nafter", "<blockquote>quoted <strong>bold</strong><br>second</blockquote>after"},
+	{">>> all\nof it", "<blockquote>all<br>of it</blockquote>"},
+	{"## heading *x*\ntext", "<h2>heading <em>x</em></h2>text"},
+	{"#nope", "#nope"},
+
+	// escapes
+	{`\*not italic\*`, "*not italic*"},
+	{`\<@1>`, "&lt;@1&gt;"},
+	{`\\**bold**`, `\<strong>bold</strong>`},
+	{`\a`, `\a`},
+	{"**a \\** b**", "<strong>a ** b</strong>"},
+
+	// code
+	{"`<b>**x**</b>`", "<code>&lt;b&gt;**x**&lt;/b&gt;</code>"},
+	{"`` a`b ``", "<code>a`b</code>"},
+	{"```go\nfmt.Println(\"<\")\n```", `<pre><code class="language-go">fmt.Println(&#34;&lt;&#34;)</code></pre>`},
+	{"```\n**x**\n<@1>\n```", "<pre><code>**x**\n&lt;@1&gt;</code></pre>"},
+	{"```one line```", "<pre><code>one line</code></pre>"},
+	{"`unclosed", "`unclosed"},
+
+	// links
+	{"see https://example.com/a_b_c.", `see <a href="https://example.com/a_b_c">https://example.com/a_b_c</a>.`},
+	{"(https://en.wikipedia.org/wiki/Go_(game))", `(<a href="https://en.wikipedia.org/wiki/Go_(game)">https://en.wikipedia.org/wiki/Go_(game)</a>)`},
+	{"<https://example.com>", `<a href="https://example.com">https://example.com</a>`},
+	{"[**masked**](https://example.com)", `<a href="https://example.com"><strong>masked</strong></a>`},
+	{"[x](<https://example.com>)", `<a href="https://example.com">x</a>`},
+	{`https://example.com/?a=1&b="x"`, `<a href="https://example.com/?a=1&amp;b=&#34;x">https://example.com/?a=1&amp;b=&#34;x</a>&#34;`},
+	{`[x](https://example.com/"onmouseover=alert(1))`, `<a href="https://example.com/&#34;onmouseover=alert(1">x</a>)`},
+	{"[x](javascript:alert(1))", "[x](javascript:alert(1))"},
+	{"[x](<javascript:alert(1)>)", "[x](&lt;javascript:alert(1)&gt;)"},
+	{"<javascript:alert(1)>", "&lt;javascript:alert(1)&gt;"},
+	{"javascript:alert(1)", "javascript:alert(1)"},
+	{"[x](data:text/html,<script>)", "[x](data:text/html,&lt;script&gt;)"},
+	{"http://", "http://"},
+
+	// mentions and emoji
+	{"<@1> <@!1> <@9>", `<span class="mention" title="1">@&lt;b&gt;&#34;user&#34;&lt;/b&gt;</span> <span class="mention" title="1">@&lt;b&gt;&#34;user&#34;&lt;/b&gt;</span> <span class="mention" title="9">@9</span>`},
+	{"<#2> <#9>", `<a class="mention" href="2.html?a=1&amp;b=&#34;x&#34;">#general &amp; more</a> <span class="mention" title="9">#9</span>`},
+	{"<@&3> <@&9>", `<span class="mention" style="color: #ff0000" title="3">@&#34;&gt;&lt;script&gt;</span> <span class="mention" title="9">@9</span>`},
+	{"@everyone", `<span class="mention">@everyone</span>`},
+	{`<:a"b:5>`, `<img class="emoji" src="emojis/5.png?&#34;" alt=":a&#34;b:" title=":a&#34;b:">`},
+	{"<a:dance:6>", `<img class="emoji" src="emojis/6.gif" alt=":dance:" title=":dance:">`},
+	{"<:x:notanid> <@x>", "&lt;:x:notanid&gt; &lt;@x&gt;"},
+}
+
+func TestHTML(t *testing.T) {
+	for _, tt := range htmlTests {
+		if got := string(HTML(Parse(tt.in), testOptions)); got != tt.out {
+			t.Errorf("%q:\ngot  %s\nwant %s", tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestHTMLWithoutOptions(t *testing.T) {
+	in := `<@1> <#2> <@&3> <:a"b:5>`
+	want := `<span class="mention" title="1">@1</span> <span class="mention" title="2">#2</span> <span class="mention" title="3">@3</span> :a&#34;b:`
+	if got := string(HTML(Parse(in), nil)); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"**bold** <@1> [link](https://example.com)", `bold @<b>"user"</b> link (https://example.com)`},
+		{"> a\n> b\nc", "> a\n> b\nc"},
+		{"```go\ncode\n```", "code\n"},
+		{"[x](javascript:alert(1))", "[x](javascript:alert(1))"},
+	}
+	for _, tt := range tests {
+		if got := PlainText(Parse(tt.in), fakeResolver{}); got != tt.out {
+			t.Errorf("%q: got %q, want %q", tt.in, got, tt.out)
+		}
+	}
+}
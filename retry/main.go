@@ -0,0 +1,106 @@
+// Package retry retries failing operations with jittered exponential backoff.
+package retry
+
+import (
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+type Policy struct {
+	Attempts int           // total number of attempts, 1 disables retrying
+	Base     time.Duration // delay before the first retry
+	Max      time.Duration // upper bound for a single delay
+}
+
+var Default = Policy{Attempts: 5, Base: time.Second, Max: time.Minute}
+
+// Classifier reports if an error is worth retrying and how long the server
+// asked to wait before the next attempt (0 if unspecified).
+type Classifier func(err error) (temporary bool, after time.Duration)
+
+// Do calls f until it succeeds, fails with a non-temporary error or the
+// number of attempts is exhausted. The last error is returned.
+func (p Policy) Do(what string, f func() error, classify Classifier) error {
+	var err error
+
+	for attempt := 1; ; attempt++ {
+		err = f()
+		if err == nil {
+			return nil
+		}
+
+		temporary, after := classify(err)
+		if !temporary || attempt >= p.Attempts {
+			return err
+		}
+
+		delay := p.delay(attempt)
+		if after > delay {
+			delay = after
+		}
+
+		log.Printf("warning: %s: %v, retrying in %v (%d/%d)", what, err, delay, attempt, p.Attempts-1)
+		time.Sleep(delay)
+	}
+}
+
+// delay returns a random duration between 0 and the exponential backoff for
+// the given attempt ("full jitter").
+func (p Policy) delay(attempt int) time.Duration {
+	d := p.Max
+	if attempt < 32 {
+		if exp := p.Base << uint(attempt-1); exp > 0 && exp < p.Max {
+			d = exp
+		}
+	}
+
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// Temporary reports if err looks like a transient network failure.
+func Temporary(err error) bool {
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// TemporaryStatus reports if an HTTP status code indicates a transient failure.
+func TemporaryStatus(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+// After parses the Retry-After header.
+func After(h http.Header) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+
+	if secs, err := strconv.ParseFloat(v, 64); err == nil && secs > 0 {
+		return time.Duration(secs * float64(time.Second))
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,160 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDelay(t *testing.T) {
+	p := Policy{Attempts: 100, Base: 10 * time.Millisecond, Max: time.Second}
+
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, 10 * time.Millisecond},
+		{2, 20 * time.Millisecond},
+		{4, 80 * time.Millisecond},
+		{7, 640 * time.Millisecond},
+		{8, time.Second},
+		{40, time.Second},
+		{100, time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if d := p.delay(tt.attempt); d < 0 || d >= tt.max {
+				t.Fatalf("delay(%d) = %v, want [0, %v)", tt.attempt, d, tt.max)
+			}
+		}
+	}
+
+	if d := (Policy{}).delay(1); d != 0 {
+		t.Errorf("zero policy: got %v", d)
+	}
+}
+
+var errTemporary = errors.New("temporary")
+
+func classify(err error) (bool, time.Duration) {
+	return errors.Is(err, errTemporary), 0
+}
+
+func TestDo(t *testing.T) {
+	p := Policy{Attempts: 3, Base: time.Millisecond, Max: time.Millisecond}
+	errPermanent := errors.New("permanent")
+
+	tests := []struct {
+		errs     []error
+		calls    int
+		last     error
+		describe string
+	}{
+		{[]error{nil}, 1, nil, "success"},
+		{[]error{errTemporary, nil}, 2, nil, "retried success"},
+		{[]error{errTemporary, errPermanent, nil}, 2, errPermanent, "permanent error"},
+		{[]error{errTemporary, errTemporary, errTemporary, nil}, 3, errTemporary, "attempts exhausted"},
+	}
+	for _, tt := range tests {
+		calls := 0
+		err := p.Do("testing", func() error {
+			calls++
+			return tt.errs[calls-1]
+		}, classify)
+		if calls != tt.calls || err != tt.last {
+			t.Errorf("%s: %d calls, %v; want %d calls, %v", tt.describe, calls, err, tt.calls, tt.last)
+		}
+	}
+}
+
+func TestDoAfter(t *testing.T) {
+	p := Policy{Attempts: 2, Base: time.Millisecond, Max: time.Millisecond}
+
+	start := time.Now()
+	calls := 0
+	p.Do("testing", func() error {
+		calls++
+		return errTemporary
+	}, func(error) (bool, time.Duration) { return true, 20 * time.Millisecond })
+	if d := time.Since(start); calls != 2 || d < 20*time.Millisecond {
+		t.Errorf("%d calls in %v, want 2 calls 20ms apart", calls, d)
+	}
+}
+
+type timeoutError struct{ timeout bool }
+
+func (e timeoutError) Error() string   { return "timeout" }
+func (e timeoutError) Timeout() bool   { return e.timeout }
+func (e timeoutError) Temporary() bool { return false }
+
+var _ net.Error = timeoutError{}
+
+func TestTemporary(t *testing.T) {
+	tests := []struct {
+		err       error
+		temporary bool
+	}{
+		{timeoutError{true}, true},
+		{timeoutError{false}, false},
+		{fmt.Errorf("reading: %w", io.ErrUnexpectedEOF), true},
+		{&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{os.NewSyscallError("connect", syscall.ECONNREFUSED), true},
+		{syscall.EPIPE, true},
+		{io.EOF, false},
+		{os.ErrNotExist, false},
+		{errors.New("other"), false},
+	}
+	for _, tt := range tests {
+		if got := Temporary(tt.err); got != tt.temporary {
+			t.Errorf("Temporary(%v) = %v, want %v", tt.err, got, tt.temporary)
+		}
+	}
+}
+
+func TestTemporaryStatus(t *testing.T) {
+	for code, temporary := range map[int]bool{
+		200: false,
+		403: false,
+		404: false,
+		408: true,
+		429: true,
+		500: true,
+		502: true,
+		503: true,
+	} {
+		if got := TemporaryStatus(code); got != temporary {
+			t.Errorf("TemporaryStatus(%d) = %v, want %v", code, got, temporary)
+		}
+	}
+}
+
+func TestAfter(t *testing.T) {
+	tests := []struct {
+		value    string
+		min, max time.Duration
+	}{
+		{"", 0, 0},
+		{"3", 3 * time.Second, 3 * time.Second},
+		{"0.5", 500 * time.Millisecond, 500 * time.Millisecond},
+		{"0", 0, 0},
+		{"-1", 0, 0},
+		{"soon", 0, 0},
+		{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), 59 * time.Minute, time.Hour},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, 0},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.value != "" {
+			h.Set("Retry-After", tt.value)
+		}
+		if got := After(h); got < tt.min || got > tt.max {
+			t.Errorf("After(%q) = %v, want between %v and %v", tt.value, got, tt.min, tt.max)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Dir stores files in a local directory.
+type Dir string
+
+func (d Dir) path(name string) string {
+	return filepath.Join(string(d), filepath.FromSlash(name))
+}
+
+func (d Dir) Open(name string) (io.ReadCloser, error) {
+	return os.Open(d.path(name))
+}
+
+func (d Dir) Stat(name string) (int64, error) {
+	fi, err := os.Stat(d.path(name))
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+func (d Dir) Append(name string) (io.WriteCloser, error) {
+	p := d.path(name)
+	if err := os.MkdirAll(filepath.Dir(p), os.ModeDir|0755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// Put moves r instead of copying it if it's a file on the same filesystem.
+func (d Dir) Put(name string, r io.ReadSeeker) error {
+	p := d.path(name)
+	if err := os.MkdirAll(filepath.Dir(p), os.ModeDir|0755); err != nil {
+		return err
+	}
+
+	if f, ok := r.(*os.File); ok {
+		// temporary files are only readable by their owner
+		if err := f.Chmod(fileMode()); err == nil {
+			if err := os.Rename(f.Name(), p); err == nil {
+				return nil
+			}
+		}
+	}
+
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (d Dir) Link(oldname, newname string) error {
+	p := d.path(newname)
+	if err := os.MkdirAll(filepath.Dir(p), os.ModeDir|0755); err != nil {
+		return err
+	}
+	os.Remove(p)
+	return os.Link(d.path(oldname), p)
+}
+
+func (d Dir) List(dir string) ([]string, error) {
+	var names []string
+	err := filepath.Walk(d.path(dir), func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(string(d), p)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return names, err
+}
+
+func (d Dir) Close() error {
+	return nil
+}
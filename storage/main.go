@@ -0,0 +1,126 @@
+// Package storage abstracts the place an archive is stored in. Names are
+// slash-separated paths relative to the archive root.
+package storage
+
+import (
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+type Storage interface {
+	// Open opens a file for reading. Errors for missing files satisfy
+	// os.IsNotExist.
+	Open(name string) (io.ReadCloser, error)
+	// Stat returns the size of a file.
+	Stat(name string) (int64, error)
+	// Append opens a file for appending, creating it if needed. Appended
+	// data might not be visible until the writer is closed.
+	Append(name string) (io.WriteCloser, error)
+	// Put stores the contents of r as a file, replacing it if it exists.
+	Put(name string, r io.ReadSeeker) error
+	// List returns the names of all files under a directory.
+	List(dir string) ([]string, error)
+	Close() error
+}
+
+// Linker is implemented by storages which can make a file available under
+// another name without copying it.
+type Linker interface {
+	Link(oldname, newname string) error
+}
+
+// Open opens the storage described by spec:
+//
+//   - s3://bucket/prefix for an S3-compatible object store, see NewS3
+//   - a path ending with .tar for a tar archive
+//   - any other path for a local directory
+func Open(spec string) (Storage, error) {
+	switch {
+	case strings.HasPrefix(spec, "s3://"):
+		bucket, prefix := spec[len("s3://"):], ""
+		if i := strings.Index(bucket, "/"); i >= 0 {
+			bucket, prefix = bucket[:i], bucket[i+1:]
+		}
+		return NewS3(bucket, prefix), nil
+	case strings.HasSuffix(spec, ".tar"):
+		return OpenTar(spec)
+	default:
+		return Dir(spec), nil
+	}
+}
+
+func notExist(op, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
+
+// inDir reports if name is under dir, an empty dir contains everything.
+func inDir(name, dir string) bool {
+	dir = path.Clean(dir)
+	return dir == "." || strings.HasPrefix(name, dir+"/")
+}
+
+// spool copies r to a temporary file, which is removed on Close.
+func spool(r io.Reader) (*tempFile, error) {
+	f, err := newTempFile()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+type tempFile struct {
+	*os.File
+}
+
+func newTempFile() (*tempFile, error) {
+	f, err := os.CreateTemp("", "pullcord-")
+	if err != nil {
+		return nil, err
+	}
+	return &tempFile{f}, nil
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+// size returns the size of r and rewinds it.
+func size(r io.Seeker) (int64, error) {
+	n, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	return n, err
+}
+
+// appender buffers appended data in a temporary file, commit is called with
+// the buffered data on Close.
+type appender struct {
+	*tempFile
+	commit func(r io.ReadSeeker) error
+}
+
+func (a *appender) Close() error {
+	defer a.tempFile.Close()
+
+	if _, err := a.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return a.commit(a.tempFile)
+}
@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3 stores files in a bucket of an S3-compatible object store, using
+// path-style requests signed with AWS Signature Version 4. Objects can't be
+// appended to, so appending to a file uploads it whole again.
+type S3 struct {
+	Endpoint  string // e.g. https://s3.amazonaws.com or http://localhost:9000
+	Region    string
+	Bucket    string
+	Prefix    string // prepended to all names
+	AccessKey string
+	SecretKey string
+
+	Client *http.Client
+}
+
+// NewS3 returns an S3 storage configured from the environment:
+// AWS_ENDPOINT_URL (https://s3.amazonaws.com by default), AWS_REGION
+// (us-east-1 by default), AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+func NewS3(bucket, prefix string) *S3 {
+	s := &S3{
+		Endpoint:  os.Getenv("AWS_ENDPOINT_URL"),
+		Region:    os.Getenv("AWS_REGION"),
+		Bucket:    bucket,
+		AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		Client:    http.DefaultClient,
+	}
+
+	if s.Endpoint == "" {
+		s.Endpoint = "https://s3.amazonaws.com"
+	}
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		s.Prefix = prefix + "/"
+	}
+
+	return s
+}
+
+// ErrS3 is returned for unexpected responses from the object store.
+type ErrS3 struct {
+	Op, Name   string
+	StatusCode int
+	Body       string
+}
+
+func (e ErrS3) Error() string {
+	return fmt.Sprintf("s3 %s %s: status %d: %s", e.Op, e.Name, e.StatusCode, e.Body)
+}
+
+func (s *S3) do(method, key string, query url.Values, body io.ReadSeeker) (*http.Response, error) {
+	u, err := url.Parse(strings.TrimSuffix(s.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	// the signature covers the path, so it's escaped here the same way
+	u.RawPath = u.EscapedPath() + "/" + uriEncode(s.Bucket, true)
+	u.Path += "/" + s.Bucket
+	if key != "" {
+		u.RawPath += "/" + uriEncode(key, false)
+		u.Path += "/" + key
+	}
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		n, err := size(body)
+		if err != nil {
+			return nil, err
+		}
+		// a non-nil body of unknown length would be sent chunked, which
+		// S3 doesn't accept
+		req.Body = http.NoBody
+		if n > 0 {
+			req.Body = io.NopCloser(body)
+		}
+		req.ContentLength = n
+	}
+
+	s.sign(req, time.Now())
+	return s.Client.Do(req)
+}
+
+func (s *S3) error(op, name string, r *http.Response) error {
+	defer r.Body.Close()
+
+	if r.StatusCode == http.StatusNotFound {
+		return notExist(op, name)
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(r.Body, 1024))
+	return ErrS3{op, name, r.StatusCode, string(body)}
+}
+
+func (s *S3) Open(name string) (io.ReadCloser, error) {
+	r, err := s.do("GET", s.Prefix+name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, s.error("open", name, r)
+	}
+	return r.Body, nil
+}
+
+func (s *S3) Stat(name string) (int64, error) {
+	r, err := s.do("HEAD", s.Prefix+name, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return 0, s.error("stat", name, r)
+	}
+	r.Body.Close()
+	return r.ContentLength, nil
+}
+
+func (s *S3) Append(name string) (io.WriteCloser, error) {
+	old, err := s.Open(name)
+	if os.IsNotExist(err) {
+		old = io.NopCloser(strings.NewReader(""))
+	} else if err != nil {
+		return nil, err
+	}
+	defer old.Close()
+
+	f, err := spool(old)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &appender{f, func(r io.ReadSeeker) error { return s.Put(name, r) }}, nil
+}
+
+func (s *S3) Put(name string, r io.ReadSeeker) error {
+	resp, err := s.do("PUT", s.Prefix+name, nil, r)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return s.error("put", name, resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type listResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s *S3) List(dir string) ([]string, error) {
+	prefix := s.Prefix
+	if d := strings.Trim(dir, "/"); d != "" && d != "." {
+		prefix += d + "/"
+	}
+
+	var names []string
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		r, err := s.do("GET", "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		if r.StatusCode != http.StatusOK {
+			return nil, s.error("list", dir, r)
+		}
+
+		var res listResult
+		err = xml.NewDecoder(r.Body).Decode(&res)
+		r.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range res.Contents {
+			names = append(names, strings.TrimPrefix(c.Key, s.Prefix))
+		}
+
+		if !res.IsTruncated {
+			return names, nil
+		}
+		query.Set("continuation-token", res.NextContinuationToken)
+	}
+}
+
+func (s *S3) Close() error {
+	return nil
+}
+
+// uriEncode encodes s as required by Signature Version 4, slashes are kept
+// as is unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func canonicalQuery(q url.Values) string {
+	var params []string
+	for k, vs := range q {
+		for _, v := range vs {
+			params = append(params, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sign adds a Signature Version 4 Authorization header to req. The payload
+// isn't signed, so it doesn't have to be read twice.
+func (s *S3) sign(req *http.Request, t time.Time) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if req.Header.Get("X-Amz-Content-Sha256") == "" {
+		req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, vs := range req.Header {
+		k = strings.ToLower(k)
+		if k == "host" || k == "range" || strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.TrimSpace(strings.Join(vs, ","))
+		}
+	}
+
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign))))
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Tar stores files in a single tar archive. Files can't be modified in place,
+// a new version of a file is added as a new entry, shadowing older ones, so
+// appending to a file rewrites it whole.
+type Tar struct {
+	mu      sync.Mutex
+	f       *os.File
+	w       *tar.Writer
+	entries map[string]tarEntry // latest entry for each name
+}
+
+type tarEntry struct {
+	offset int64 // of the contents
+	size   int64
+}
+
+// countingReader counts bytes read through it, archive/tar doesn't read ahead,
+// so the count is at the contents of an entry right after Next.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// OpenTar opens a tar archive, creating it if it doesn't exist. New entries
+// are added after the existing ones.
+func OpenTar(fpath string) (*Tar, error) {
+	f, err := os.OpenFile(fpath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tar{f: f, entries: make(map[string]tarEntry)}
+
+	end := int64(0)
+	cr := &countingReader{r: f}
+	tr := tar.NewReader(cr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		if h.Typeflag == tar.TypeReg {
+			t.entries[h.Name] = tarEntry{cr.n, h.Size}
+		}
+		// contents are padded to the block size
+		end = cr.n + (h.Size+511)/512*512
+	}
+
+	// the end of archive marker is overwritten by new entries
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	t.w = tar.NewWriter(f)
+
+	return t, nil
+}
+
+func (t *Tar) Open(name string) (io.ReadCloser, error) {
+	t.mu.Lock()
+	e, ok := t.entries[name]
+	t.mu.Unlock()
+
+	if !ok {
+		return nil, notExist("open", name)
+	}
+	return io.NopCloser(io.NewSectionReader(t.f, e.offset, e.size)), nil
+}
+
+func (t *Tar) Stat(name string) (int64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[name]
+	if !ok {
+		return 0, notExist("stat", name)
+	}
+	return e.size, nil
+}
+
+func (t *Tar) Append(name string) (io.WriteCloser, error) {
+	f, err := newTempFile()
+	if err != nil {
+		return nil, err
+	}
+
+	return &appender{f, func(r io.ReadSeeker) error {
+		n, err := size(r)
+		if err != nil {
+			return err
+		}
+
+		t.mu.Lock()
+		old := t.entries[name]
+		t.mu.Unlock()
+
+		return t.put(name, io.MultiReader(io.NewSectionReader(t.f, old.offset, old.size), r), old.size+n)
+	}}, nil
+}
+
+func (t *Tar) Put(name string, r io.ReadSeeker) error {
+	n, err := size(r)
+	if err != nil {
+		return err
+	}
+	return t.put(name, r, n)
+}
+
+func (t *Tar) put(name string, r io.Reader, n int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	err := t.w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     n,
+		Mode:     0644,
+		ModTime:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.CopyN(t.w, r, n); err != nil {
+		return err
+	}
+
+	// the writer pads the contents when the next entry starts
+	if err := t.w.Flush(); err != nil {
+		return err
+	}
+
+	end, err := t.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	t.entries[name] = tarEntry{end - (n+511)/512*512, n}
+	return nil
+}
+
+func (t *Tar) List(dir string) ([]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var names []string
+	for name := range t.entries {
+		if inDir(name, dir) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Close writes the end of archive marker and closes the archive.
+func (t *Tar) Close() error {
+	err := t.w.Close()
+	if cerr := t.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
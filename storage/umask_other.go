@@ -0,0 +1,10 @@
+//go:build !unix
+
+package storage
+
+import "os"
+
+// fileMode returns the mode os.Create would give a new file.
+func fileMode() os.FileMode {
+	return 0666
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// umask is read during initialization, while no other goroutines can be
+// creating files: it can only be read by changing it.
+var umask = func() os.FileMode {
+	m := syscall.Umask(0)
+	syscall.Umask(m)
+	return os.FileMode(m)
+}()
+
+// fileMode returns the mode os.Create would give a new file.
+func fileMode() os.FileMode {
+	return 0666 &^ umask
+}
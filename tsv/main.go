@@ -3,6 +3,8 @@ package tsv
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"io"
 	"strings"
 )
@@ -13,25 +15,174 @@ var subs = [][]string{
 	[]string{"\t", "\\t"},
 }
 
-func Read(s *bufio.Scanner) []string {
-	record := strings.Split(s.Text(), "\t")
+// ErrEscape is returned in strict mode for backslashes which don't start a
+// valid escape sequence.
+var ErrEscape = errors.New("invalid escape sequence")
+
+// unescape splits a line into fields and decodes escape sequences. Invalid
+// sequences are kept as is unless strict is set.
+func unescape(line string, strict bool) ([]string, error) {
+	var record []string
+	var b strings.Builder
+
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; c {
+		case '\t':
+			record = append(record, b.String())
+			b.Reset()
+		case '\\':
+			if i+1 == len(line) {
+				if strict {
+					return nil, ErrEscape
+				}
+				b.WriteByte(c)
+				break
+			}
 
-	for i := range record {
-		for j := len(subs) - 1; j >= 0; j-- {
-			record[i] = strings.Replace(record[i], subs[j][1], subs[j][0], -1)
+			i++
+			switch line[i] {
+			case '\\':
+				b.WriteByte('\\')
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				if strict {
+					return nil, ErrEscape
+				}
+				// the next character is read on its own, it can be
+				// a separator
+				b.WriteByte(c)
+				i--
+			}
+		default:
+			b.WriteByte(c)
 		}
 	}
 
+	return append(record, b.String()), nil
+}
+
+// UnescapeLegacy decodes a field the way Read did before escape sequences were
+// decoded in a single pass, which mangled backslashes followed by "n" or "t".
+// It's only useful for finding records written from mangled data.
+func UnescapeLegacy(field string) string {
+	for j := len(subs) - 1; j >= 0; j-- {
+		field = strings.Replace(field, subs[j][1], subs[j][0], -1)
+	}
+	return field
+}
+
+// Escape encodes a single field.
+func Escape(field string) string {
+	for j := 0; j < len(subs); j++ {
+		field = strings.Replace(field, subs[j][0], subs[j][1], -1)
+	}
+	return field
+}
+
+func escape(record []string) string {
+	fields := make([]string, len(record))
+	for i, f := range record {
+		fields[i] = Escape(f)
+	}
+
+	return strings.Join(fields, "\t") + "\n"
+}
+
+// Read decodes the line last read by s.
+//
+// Deprecated: bufio.Scanner fails on long lines and drops trailing carriage
+// returns, use Decoder.
+func Read(s *bufio.Scanner) []string {
+	record, _ := unescape(s.Text(), false)
 	return record
 }
 
+// Write writes a record to w, record isn't modified.
 func Write(w io.Writer, record []string) error {
-	for i := range record {
-		for j := 0; j < len(subs); j++ {
-			record[i] = strings.Replace(record[i], subs[j][0], subs[j][1], -1)
+	_, err := io.WriteString(w, escape(record))
+	return err
+}
+
+// ParseError is returned by Decoder for records which can't be read. File
+// is left for the caller to fill in.
+type ParseError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ParseError) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+	}
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// Decoder reads records from a TSV file. There's no limit on the length of
+// records.
+type Decoder struct {
+	Strict bool // if true, invalid escape sequences are errors
+
+	r    *bufio.Reader
+	raw  string
+	line int
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode returns the next record, or io.EOF if there are no more.
+func (d *Decoder) Decode() ([]string, error) {
+	line, err := d.r.ReadString('\n')
+	if err == io.EOF {
+		// the last record doesn't have to end with a line feed
+		if line == "" {
+			return nil, io.EOF
 		}
+	} else if err != nil {
+		return nil, &ParseError{Line: d.line + 1, Err: err}
 	}
 
-	_, err := w.Write([]byte(strings.Join(record, "\t") + "\n"))
-	return err
+	d.line++
+	// carriage returns aren't escaped, a trailing one belongs to the last
+	// field
+	d.raw = strings.TrimSuffix(line, "\n")
+
+	record, err := unescape(d.raw, d.Strict)
+	if err != nil {
+		return nil, &ParseError{Line: d.line, Err: err}
+	}
+	return record, nil
+}
+
+// Line returns the line number of the last decoded record, starting at 1.
+func (d *Decoder) Line() int {
+	return d.line
+}
+
+// Raw returns the last decoded record as it was read, without the line feed.
+func (d *Decoder) Raw() string {
+	return d.raw
+}
+
+// Encoder writes records to a TSV file.
+type Encoder struct {
+	w io.Writer
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w}
+}
+
+// Encode writes a record, record isn't modified.
+func (e *Encoder) Encode(record []string) error {
+	return Write(e.w, record)
 }
@@ -0,0 +1,133 @@
+package tsv
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var unescapeTests = []struct {
+	line   string
+	record []string
+	strict bool // if false, strict mode rejects the line
+}{
+	{"", []string{""}, true},
+	{"a\tb", []string{"a", "b"}, true},
+	{"a\t", []string{"a", ""}, true},
+	{`a\tb`, []string{"a\tb"}, true},
+	{`a\nb`, []string{"a\nb"}, true},
+	{`a\\b`, []string{`a\b`}, true},
+	// escaped backslashes followed by n or t, which the old decoder mangled
+	{`C:\\new`, []string{`C:\new`}, true},
+	{`\\t`, []string{`\t`}, true},
+	{`\\\t`, []string{"\\\t"}, true},
+	{`\\\\n`, []string{`\\n`}, true},
+	{"\\n\t\\t", []string{"\n", "\t"}, true},
+	// carriage returns aren't escaped
+	{"a\r\tb\r", []string{"a\r", "b\r"}, true},
+	// invalid sequences are kept as they are
+	{`a\qb`, []string{`a\qb`}, false},
+	{`a\`, []string{`a\`}, false},
+	{`\`, []string{`\`}, false},
+	{"a\\\tb", []string{`a\`, "b"}, false},
+}
+
+func TestUnescape(t *testing.T) {
+	for _, tt := range unescapeTests {
+		record, err := unescape(tt.line, false)
+		if err != nil || !reflect.DeepEqual(record, tt.record) {
+			t.Errorf("unescape(%q) = %q, %v, want %q", tt.line, record, err, tt.record)
+		}
+
+		record, err = unescape(tt.line, true)
+		if tt.strict && (err != nil || !reflect.DeepEqual(record, tt.record)) {
+			t.Errorf("strict unescape(%q) = %q, %v, want %q", tt.line, record, err, tt.record)
+		} else if !tt.strict && err != ErrEscape {
+			t.Errorf("strict unescape(%q) = %q, %v, want ErrEscape", tt.line, record, err)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	records := [][]string{
+		{""},
+		{"a", "b", ""},
+		{`C:\new\table`, "line 1\nline 2", "tab\there"},
+		{`\`, `\\`, `\n`, `\t`, "\\\n"},
+		{"line 1\r\nline 2\r", "\r"},
+		{strings.Repeat("long ", 100000)},
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dec := NewDecoder(&buf)
+	dec.Strict = true
+	for i, want := range records {
+		got, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("record %d: got %.50q, want %.50q", i, got, want)
+		}
+		if dec.Line() != i+1 {
+			t.Errorf("record %d: line %d", i, dec.Line())
+		}
+	}
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+}
+
+func TestWriteDoesntModify(t *testing.T) {
+	record := []string{"a\tb", `c\d`}
+	if err := Write(io.Discard, record); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a\tb", `c\d`}; !reflect.DeepEqual(record, want) {
+		t.Errorf("record changed to %q", record)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	in := "a\tb\n" + `bad\q` + "\n" + "last"
+	dec := NewDecoder(strings.NewReader(in))
+	dec.Strict = true
+
+	if r, err := dec.Decode(); err != nil || !reflect.DeepEqual(r, []string{"a", "b"}) {
+		t.Errorf("line 1: got %q, %v", r, err)
+	}
+
+	_, err := dec.Decode()
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Line != 2 || !errors.Is(err, ErrEscape) {
+		t.Errorf("line 2: got %v, want a ParseError for line 2", err)
+	}
+	if dec.Raw() != `bad\q` {
+		t.Errorf("line 2: raw %q", dec.Raw())
+	}
+
+	// the decoder goes on after invalid lines
+	if r, err := dec.Decode(); err != nil || !reflect.DeepEqual(r, []string{"last"}) {
+		t.Errorf("line 3: got %q, %v", r, err)
+	}
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+}
+
+func TestUnescapeLegacy(t *testing.T) {
+	// the way `C:\new` used to be read back
+	if got := UnescapeLegacy(Escape(`C:\new`)); got != "C:\\\n"+"ew" {
+		t.Errorf("got %q", got)
+	}
+}